@@ -0,0 +1,28 @@
+package admin
+
+import (
+	response "verda/pkg"
+	"verda/pkg/upload"
+	"verda/start"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// ListUploadsHandler 列出进行中的上传会话以及孤立的分片、临时目录及其占用的磁盘空间
+func ListUploadsHandler(ctx *fiber.Ctx) error {
+	usage, err := upload.Inspect(*start.UploadTTL)
+	if err != nil {
+		return errors.WithMessage(err, "获取上传占用失败")
+	}
+	return ctx.JSON(response.Success(usage, ctx))
+}
+
+// CollectHandler 立即清理过期的上传会话和孤立文件
+func CollectHandler(ctx *fiber.Ctx) error {
+	reclaimed, err := upload.Collect(*start.UploadTTL)
+	if err != nil {
+		return errors.WithMessage(err, "清理过期上传失败")
+	}
+	return ctx.JSON(response.Success(reclaimed, ctx))
+}
@@ -0,0 +1,12 @@
+package admin
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func Register(api fiber.Router) {
+	admin := api.Group("/admin")
+
+	admin.Get("/uploads", ListUploadsHandler)
+	admin.Post("/gc", CollectHandler)
+}
@@ -0,0 +1,102 @@
+package jobs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	response "verda/pkg"
+	"verda/pkg/job"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// ListJobsHandler 获取所有任务（包括历史任务）
+func ListJobsHandler(ctx *fiber.Ctx) error {
+	list, err := job.List()
+	if err != nil {
+		return errors.WithMessage(err, "获取任务列表失败")
+	}
+	return ctx.JSON(response.Success(list, ctx))
+}
+
+// GetJobHandler 获取任务状态及每个包的处理结果
+func GetJobHandler(ctx *fiber.Ctx) error {
+	j, err := job.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
+
+// JobEventsHandler 以 SSE 订阅任务进度，会先推送已完成的结果
+func JobEventsHandler(ctx *fiber.Ctx) error {
+	j, err := job.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return Stream(ctx, j)
+}
+
+// CancelJobHandler 取消任务
+func CancelJobHandler(ctx *fiber.Ctx) error {
+	if err := job.Cancel(ctx.Params("id")); err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(true, ctx))
+}
+
+type eventVO struct {
+	Pkg      string `json:"pkg"`
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+	Detail   any    `json:"detail,omitempty"`
+	Progress int64  `json:"progress"`
+	Total    int64  `json:"total"`
+}
+
+// Stream 以 SSE 推送任务进度，每个包一条 message 事件，任务结束时推送 done 事件（数据为任务状态）
+func Stream(ctx *fiber.Ctx, j *job.Job) error {
+	ctx.Set("Content-Type", "text/event-stream")
+	ctx.Set("Cache-Control", "no-cache")
+	ctx.Set("Connection", "keep-alive")
+
+	history, events, unsubscribe := j.Subscribe()
+
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		write := func(event job.Event) error {
+			data, _ := json.Marshal(eventVO{
+				Pkg:      event.Result.Pkg,
+				Result:   event.Result.Result,
+				Error:    event.Result.Error,
+				Detail:   event.Result.Detail,
+				Progress: event.Progress,
+				Total:    event.Total,
+			})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			return w.Flush()
+		}
+
+		for _, event := range history {
+			if write(event) != nil {
+				return
+			}
+		}
+		for event := range events {
+			// 客户端断开连接
+			if write(event) != nil {
+				return
+			}
+		}
+
+		status := j.Status()
+		status.Results = nil
+		data, _ := json.Marshal(status)
+		fmt.Fprintf(w, "event: done\ndata: %s\n\n", data)
+		w.Flush()
+	})
+
+	return nil
+}
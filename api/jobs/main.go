@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func Register(api fiber.Router) {
+	jobs := api.Group("/jobs")
+
+	jobs.Get("/", ListJobsHandler)
+	jobs.Get("/:id", GetJobHandler)
+	jobs.Get("/:id/events", JobEventsHandler)
+	jobs.Post("/:id/cancel", CancelJobHandler)
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"github.com/gofiber/fiber/v2"
+	"verda/api/admin"
+	"verda/api/jobs"
 	"verda/api/storage"
 )
 
@@ -9,4 +11,6 @@ func Register(app *fiber.App) {
 	api := app.Group("/api")
 
 	storage.Register(api)
+	admin.Register(api)
+	jobs.Register(api)
 }
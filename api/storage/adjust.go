@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	response "verda/pkg"
+	"verda/pkg/config"
+	"verda/pkg/verdaccio"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+type AdjustPackageResultVO struct {
+	DryRun bool `json:"dryRun"`
+	// 有修改的包的数量
+	Adjusted int `json:"adjusted"`
+	// 每个包的修改明细及整理前后的版本和 dist-tags，没有修改的包各修改字段为空
+	Packages []verdaccio.AdjustChange `json:"packages"`
+}
+
+// AdjustPackageHandler 整理单个包（name 或 @scope/name）。查询参数 dryRun 为 true 时直接返回整理前后的差异，不写入文件；
+// 否则提交整理任务，返回任务信息，任务结果中包含同样的差异
+func AdjustPackageHandler(ctx *fiber.Ctx) error {
+	name, err := unescapeParam(ctx, "+")
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, "@") && !strings.Contains(name, "/") {
+		return errors.New("整理 scope 请使用 /adjust/scopes/" + name)
+	}
+	return adjustStoragePath(ctx, name)
+}
+
+// AdjustScopeHandler 整理 scope 下的所有包。查询参数 dryRun 为 true 时直接返回每个包整理前后的差异，不写入文件；
+// 否则提交整理任务，返回任务信息
+func AdjustScopeHandler(ctx *fiber.Ctx) error {
+	scope, err := unescapeParam(ctx, "scope")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(scope, "@") {
+		scope = "@" + scope
+	}
+	if strings.Contains(scope, "/") {
+		return errors.New("非法的 scope：" + scope)
+	}
+	return adjustStoragePath(ctx, scope)
+}
+
+func unescapeParam(ctx *fiber.Ctx, key string) (string, error) {
+	raw := ctx.Params(key)
+	if raw == "" {
+		return "", errors.New("包名不能为空")
+	}
+	name, err := url.QueryUnescape(raw)
+	if err != nil {
+		name = raw
+	}
+	// 防止越权访问
+	if strings.Contains(name, "..") || strings.Contains(name, `\`) {
+		return "", errors.New("非法的包名：" + name)
+	}
+	return name, nil
+}
+
+// adjustStoragePath 整理 storage 中的包或 scope name。预览只读取文件，直接返回差异；
+// 写入时提交任务，与整理 storage 使用同一任务队列，写入前记录快照
+func adjustStoragePath(ctx *fiber.Ctx, name string) error {
+	storagePath, err := verdaccio.GetStoragePath()
+	if err != nil {
+		return errors.WithMessage(err, "获取 storage 路径失败")
+	}
+	packages := []string{name}
+	if strings.HasPrefix(name, "@") && !strings.Contains(name, "/") {
+		if !utils.IsDir(filepath.Join(storagePath, name)) {
+			return errors.New("scope 不存在：" + name)
+		}
+		if packages, err = verdaccio.ScopePackages(name); err != nil {
+			return err
+		}
+		if len(packages) == 0 {
+			return errors.New("scope 下没有包：" + name)
+		}
+	} else if !utils.PathExists(filepath.Join(storagePath, name, "package.json")) {
+		return errors.New("包不存在：" + name)
+	}
+
+	if ctx.QueryBool("dryRun") {
+		changes, err := verdaccio.AdjustPackage(filepath.Join(storagePath, name), config.Get().Normalizer(), true)
+		if err != nil {
+			return errors.WithMessagef(err, "预览整理 %s 失败", name)
+		}
+		vo := AdjustPackageResultVO{DryRun: true, Packages: changes}
+		for _, c := range changes {
+			if !c.Empty() {
+				vo.Adjusted++
+			}
+		}
+		return ctx.JSON(response.Success(vo, ctx))
+	}
+
+	j, err := submitAdjustJob("整理 "+name, false, packages)
+	if err != nil {
+		return errors.WithMessagef(err, "整理 %s 失败", name)
+	}
+	log.Infof("%s 提交了整理 %s 的任务 %s", operator(ctx), name, j.ID())
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
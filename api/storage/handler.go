@@ -1,16 +1,18 @@
 package storage
 
 import (
-	"bufio"
-	"fmt"
+	"context"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"sort"
 	"strconv"
 	"strings"
+	"verda/api/jobs"
 	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/history"
+	"verda/pkg/upload"
+	"verda/pkg/upstream"
 	"verda/pkg/verdaccio"
 	"verda/utils"
 
@@ -19,10 +21,9 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/samber/lo"
 )
 
-const ChunkDir = "chunk"
-
 func UploadHandler(ctx *fiber.Ctx) error {
 	index := ctx.FormValue("index")
 	chunkSize := ctx.FormValue("chunkSize")
@@ -39,7 +40,7 @@ func UploadHandler(ctx *fiber.Ctx) error {
 		return errors.Wrap(err, "chunkSize 参数错误")
 	}
 
-	chunkDir, _ := filepath.Abs(ChunkDir)
+	chunkDir, _ := filepath.Abs(upload.ChunkDir)
 
 	if !utils.PathExists(chunkDir) {
 		if err = os.Mkdir(chunkDir, os.ModePerm); err != nil {
@@ -71,75 +72,75 @@ func PatchHandler(ctx *fiber.Ctx) error {
 		return errors.Wrap(err, "参数解析错误")
 	}
 
-	outputFile, err := mergeFile(p.Filename, p.FileList, p.MD5)
-	defer outputFile.Close()
-	defer os.Remove(outputFile.Name())
-
+	chunks, err := legacyChunks(p.FileList)
 	if err != nil {
-		return errors.WithMessage(err, "文件合并失败")
+		return err
 	}
 
-	pwd, err := os.Getwd()
+	limits, err := uploadLimits()
 	if err != nil {
-		return errors.Wrap(err, "无法获取当前目录")
+		return err
 	}
-
-	if err = utils.Unzip(outputFile.Name(), pwd); err != nil {
-		return errors.WithMessage(err, "解压失败")
+	var size int64
+	for _, chunk := range chunks {
+		size += utils.DirSize(chunk)
+	}
+	// 分片已在磁盘上，只需检查合并及解压所需的空间
+	if err = upload.CheckDiskSpace(upload.BundleRequirements(size, *limits)[1:]); err != nil {
+		return err
 	}
-	patchDir := filepath.Join(pwd, "storage-patch")
-	defer os.RemoveAll(patchDir)
-
-	channel := make(chan verdaccio.PatchMessage)
-	err = verdaccio.PatchStorage(patchDir, channel)
 
+	ws, err := upload.NewWorkspace()
 	if err != nil {
-		return errors.WithMessage(err, "打补丁失败")
+		return err
 	}
 
-	for msg := range channel {
-		p := float64(msg.Progress) / float64(msg.Total) * 100
-		log.Debugf("[%.2f%%] patch %s %s\n", p, msg.Pkg, msg.PatchResult)
-		if msg.Total == msg.Progress {
-			close(channel)
+	// 合并、解压都在后台任务中进行
+	j, err := submitPatchJob(filepath.Base(p.Filename), operator(ctx), ctx.QueryBool("force"), func(ctx context.Context) (string, error) {
+		outputFilePath := filepath.Join(ws.Dir, filepath.Base(p.Filename))
+		if err := upload.MergeChunks(outputFilePath, chunks, p.MD5); err != nil {
+			return "", errors.WithMessage(err, "文件合并失败")
 		}
+		return bundle.Open(ws, outputFilePath)
+	}, func() { ws.Close() })
+	if err != nil {
+		return err
 	}
 
-	return ctx.JSON(response.Success("打补丁成功", ctx))
+	return ctx.JSON(response.Success(j.Status(), ctx))
 }
 
+// AdjustStorageHandler 提交整理 storage 的任务，并以 SSE 返回进度，查询参数 dryRun 为 true 时只生成修改明细
 func AdjustStorageHandler(ctx *fiber.Ctx) error {
-	ctx.Set("Content-Type", "text/event-stream")
-	ctx.Set("Cache-Control", "no-cache")
-	ctx.Set("Connection", "keep-alive")
-
-	channel := make(chan verdaccio.AjustMessage)
-	err := verdaccio.AdjustStorage(channel)
-
+	j, err := submitAdjustJob(adjustTitle(nil), ctx.QueryBool("dryRun"), nil)
 	if err != nil {
 		return errors.WithMessage(err, "整理storage失败")
 	}
+	return jobs.Stream(ctx, j)
+}
 
-	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
-		for msg := range channel {
-			p := float64(msg.Progress) / float64(msg.Total) * 100
-			log.Debugf("[%.2f%%] adjust %s %s\n", p, msg.Pkg, msg.AdjustResult)
-
-			data := fmt.Sprintf(`{"pkg":"%s","result":"%s","progress":%d,"total":%d}`,
-				msg.Pkg, msg.AdjustResult, msg.Progress, msg.Total)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			w.Flush()
+type AdjustVO struct {
+	DryRun bool `json:"dryRun" form:"dryRun"`
+	// 只整理这些包，为空时整理所有包
+	Packages []string `json:"packages" form:"packages"`
+}
 
-			if msg.Total == msg.Progress {
-				close(channel)
-			}
+// StartAdjustHandler 提交整理 storage 的任务，返回任务信息。dryRun 为 true 时只生成每个包的修改明细（见任务结果），
+// 确认后将需要整理的包放入 packages 再次提交
+func StartAdjustHandler(ctx *fiber.Ctx) error {
+	p := new(AdjustVO)
+	// 请求体可以为空，此时直接整理所有包
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(p); err != nil {
+			return errors.Wrap(err, "参数解析错误")
 		}
-
-		fmt.Fprintf(w, "event: done\ndata: {}\n\n")
-		w.Flush()
-	})
-
-	return nil
+	}
+	packages := lo.Uniq(p.Packages)
+	j, err := submitAdjustJob(adjustTitle(packages), p.DryRun, packages)
+	if err != nil {
+		return errors.WithMessage(err, "整理storage失败")
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
 }
 
 // ListStoragePackagesHandler 分页获取 verdaccio storage 下的包，支持模糊查询包名
@@ -214,7 +215,7 @@ func ListStoragePackagesHandler(ctx *fiber.Ctx) error {
 	}, ctx))
 }
 
-// GetStoragePackageHandler 获取 storage 下某个包的完整详情（含 versions、dist-tags、time、readme 等）
+// GetStoragePackageHandler 获取 storage 下某个包的完整详情（含 versions、dist-tags、time、readme 等）及各版本的来源
 // 路径示例：/api/storage/packages/lodash 或 /api/storage/packages/@vue%2Freactivity
 func GetStoragePackageHandler(ctx *fiber.Ctx) error {
 	raw := ctx.Params("+")
@@ -254,64 +255,56 @@ func GetStoragePackageHandler(ctx *fiber.Ctx) error {
 
 	dists, _ := verdaccio.GetLocalDistFiles(pkgPath)
 
+	arrivals, err := history.ForPackage(name)
+	if err != nil {
+		log.Errorf("获取 patch 历史失败 %s: %v", name, err)
+		arrivals = []history.Arrival{}
+	}
+
+	// 上游存在但未同步的版本
+	notMirrored, err := upstream.Get(name)
+	if err != nil {
+		log.Errorf("获取上游版本失败 %s: %v", name, err)
+		notMirrored = &upstream.Package{Name: name, Versions: []upstream.Version{}, DistTags: map[string]string{}}
+	}
+
 	return ctx.JSON(response.Success(fiber.Map{
 		"package":    pkg,
 		"dependents": dependents,
 		"distFiles":  dists,
+		"history":    arrivals,
+		"upstream":   notMirrored,
 	}, ctx))
 }
 
-// 合并文件
-func mergeFile(filename string, fileList []string, md5 string) (*os.File, error) {
-	// 对分片文件排序
-	sort.SliceStable(fileList, func(i, j int) bool {
-		reg := regexp.MustCompile(`\d+`)
-
-		preMatch := reg.FindAllString(fileList[i], -1)
-		currMatch := reg.FindAllString(fileList[j], -1)
-
-		var (
-			preIndex  = 0
-			currIndex = 1
-		)
-		if len(preMatch) > 0 && len(currMatch) > 0 {
-			preIndex, _ = strconv.Atoi(preMatch[len(preMatch)-1])
-			currIndex, _ = strconv.Atoi(currMatch[len(currMatch)-1])
-		}
-		return preIndex < currIndex
-	})
-
-	chunkDir, _ := filepath.Abs(ChunkDir)
+// legacyChunks 将旧版上传接口返回的分片文件名（uuid-index）按声明的分片序号排序，返回分片路径
+func legacyChunks(fileList []string) ([]string, error) {
+	chunkDir, _ := filepath.Abs(upload.ChunkDir)
 	if !utils.PathExists(chunkDir) {
 		return nil, errors.New("分片文件夹不存在")
 	}
 
-	outputFilePath, _ := filepath.Abs(filename)
-	outputFile, err := os.Create(outputFilePath)
-	if err != nil {
-		return nil, errors.Wrap(err, "创建合并文件失败")
-	}
-
+	indexes := make(map[int]string, len(fileList))
 	for _, file := range fileList {
-		// 合并分片
-		chunkPath := filepath.Join(chunkDir, file)
-		chunkFile, err := os.ReadFile(chunkPath)
-		if err != nil {
-			return nil, errors.Wrapf(err, "分片 %s 读取失败", file)
+		file = filepath.Base(file)
+		sep := strings.LastIndex(file, "-")
+		index, err := strconv.Atoi(file[sep+1:])
+		if sep < 0 || err != nil || index < 0 {
+			return nil, errors.Errorf("无法识别分片序号：%s", file)
 		}
-
-		if _, err = outputFile.Write(chunkFile); err != nil {
-			return nil, errors.Wrapf(err, "分片 %s 合并失败", file)
-		}
-		// 删除分片
-		if err = os.Remove(chunkPath); err != nil {
-			return nil, errors.Wrapf(err, "删除分片 %s 失败", file)
+		if _, ok := indexes[index]; ok {
+			return nil, errors.Errorf("分片序号重复：%d", index)
 		}
+		indexes[index] = filepath.Join(chunkDir, file)
 	}
 
-	if fileMD5 := utils.FileMD5(outputFilePath); fileMD5 != md5 {
-		return nil, errors.New("文件已损坏")
+	chunks := make([]string, 0, len(indexes))
+	for index := 0; index < len(indexes); index++ {
+		chunk, ok := indexes[index]
+		if !ok {
+			return nil, errors.Errorf("缺少分片：%d", index)
+		}
+		chunks = append(chunks, chunk)
 	}
-
-	return outputFile, nil
+	return chunks, nil
 }
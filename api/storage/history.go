@@ -0,0 +1,27 @@
+package storage
+
+import (
+	response "verda/pkg"
+	"verda/pkg/history"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// ListHistoryHandler 获取所有 patch 运行记录
+func ListHistoryHandler(ctx *fiber.Ctx) error {
+	list, err := history.List()
+	if err != nil {
+		return errors.WithMessage(err, "获取 patch 历史失败")
+	}
+	return ctx.JSON(response.Success(list, ctx))
+}
+
+// GetHistoryHandler 获取运行记录中每个包新增的版本及变化的 dist-tags
+func GetHistoryHandler(ctx *fiber.Ctx) error {
+	r, err := history.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(r, ctx))
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/job"
+	"verda/pkg/upload"
+	"verda/start"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+type ImportVO struct {
+	Path   string `json:"path" form:"path"`
+	DryRun bool   `json:"dryRun" form:"dryRun"`
+	// 补丁包序号不连续时仍然应用
+	Force bool `json:"force" form:"force"`
+}
+
+// ImportHandler 从服务器本地路径（zip/tar 或已解压的 storage-patch 目录）导入补丁包，返回导入任务；
+// dryRun 为 true 时提交生成预览的任务，变更报告保存在任务的 detail 中
+func ImportHandler(ctx *fiber.Ctx) error {
+	p := new(ImportVO)
+	if err := ctx.BodyParser(p); err != nil {
+		return errors.Wrap(err, "参数解析错误")
+	}
+
+	path, err := bundle.AllowedPath(p.Path, strings.Split(*start.ImportRoots, ","))
+	if err != nil {
+		return err
+	}
+
+	limits, err := uploadLimits()
+	if err != nil {
+		return err
+	}
+	size := utils.DirSize(path)
+	requirements := []upload.Requirement{{Path: limits.StoragePath, Bytes: size}}
+	if !utils.IsDir(path) {
+		// 压缩包需要先解压到工作目录
+		requirements = upload.BundleRequirements(size, *limits)[1:]
+	}
+	if err = upload.CheckDiskSpace(requirements); err != nil {
+		return err
+	}
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		return err
+	}
+
+	var j *job.Job
+	if p.DryRun {
+		j, err = submitPreviewJob(path, ws, func(ctx context.Context) (string, error) {
+			return path, nil
+		})
+	} else {
+		j, err = submitPatchJob(path, operator(ctx), p.Force, func(ctx context.Context) (string, error) {
+			return bundle.Open(ws, path)
+		}, func() { ws.Close() })
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"verda/pkg/bundle"
+	"verda/pkg/config"
+	"verda/pkg/history"
+	"verda/pkg/job"
+	"verda/pkg/snapshot"
+	"verda/pkg/verdaccio"
+	"verda/start"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+// OperatorHeader 记录操作人的请求头，通常由前置的认证代理设置
+const OperatorHeader = "X-Verda-Operator"
+
+// ioLimiter 所有任务共享的 IO 限速器，-io-limit 在启动时已校验
+var ioLimiter = sync.OnceValue(func() *utils.RateLimiter {
+	limit, _ := utils.ParseSize(*start.IOLimit)
+	return utils.NewRateLimiter(limit)
+})
+
+// workerOptions 根据启动参数生成并发与 IO 限制
+func workerOptions() verdaccio.WorkerOptions {
+	return verdaccio.WorkerOptions{
+		Concurrency: *start.Concurrency,
+		Limiter:     ioLimiter(),
+	}
+}
+
+// patchOptions 根据启动参数和配置文件生成 patch 选项，受保护的包在每次调用时重新读取
+func patchOptions() (verdaccio.PatchOptions, error) {
+	opts := verdaccio.PatchOptions{
+		WorkerOptions:  workerOptions(),
+		ConflictPolicy: verdaccio.ConflictPolicy(*start.ConflictPolicy),
+		QuarantineDir:  filepath.Join(*start.DataDir, "quarantine"),
+	}
+	c := config.Get()
+	if !c.Rewrite.Disabled {
+		if opts.Rewriter = c.Rewriter(start.ExplicitRegistry()); opts.Rewriter == nil {
+			log.Info("未配置 rewrite.target 且未指定 -registry，跳过 registry 地址替换")
+		}
+	}
+	opts.Normalizer = c.Normalizer()
+	opts.Policy = c.AdmissionPolicy()
+	opts.Renamer = c.Renamer()
+	protection, err := c.Protection()
+	if err != nil {
+		return opts, errors.WithMessage(err, "读取受保护的包失败")
+	}
+	opts.Protection = protection
+	return opts, nil
+}
+
+// checkSequence 按配置检查补丁包序号，配置为 refuse 且 force 为 false 时序号不连续返回错误，否则返回警告信息
+func checkSequence(manifest *bundle.Manifest, force bool) (string, error) {
+	action := config.Get().SequenceAction()
+	if action == "" {
+		return "", nil
+	}
+	warning, err := history.EnforceSequence(manifest, action == history.SequenceRefuse && !force)
+	if warning != "" {
+		log.Warnf("补丁包序号不连续：%s", warning)
+	}
+	return warning, err
+}
+
+// operator 获取请求的操作人，优先使用请求头 X-Verda-Operator，否则使用客户端 IP
+func operator(ctx *fiber.Ctx) string {
+	if name := strings.TrimSpace(ctx.Get(OperatorHeader)); name != "" {
+		return name
+	}
+	return ctx.IP()
+}
+
+// submitPatchJob 提交打补丁任务，prepare 在任务执行时准备补丁目录，cleanups 在任务结束后执行。
+// operator 为操作人，记录在 patch 历史中；force 为 true 时补丁包序号不连续也会应用
+func submitPatchJob(title, operator string, force bool, prepare func(ctx context.Context) (string, error), cleanups ...func()) (*job.Job, error) {
+	return job.Submit("patch", title, func(ctx context.Context, j *job.Job) error {
+		patchDir, err := prepare(ctx)
+		if err != nil {
+			return err
+		}
+
+		opts, err := patchOptions()
+		if err != nil {
+			return err
+		}
+		opts.OnStart = j.SetTotal
+		// 按清单校验补丁包，没有清单的旧版补丁包跳过校验
+		manifest, err := bundle.Verify(patchDir, opts.Limiter)
+		if manifest != nil {
+			j.SetDetail(manifest)
+		}
+		if err != nil {
+			return err
+		}
+		warning, err := checkSequence(manifest, force)
+		if err != nil {
+			return err
+		}
+
+		// 快照、历史记录与任务使用相同的 ID，可通过任务 ID 回滚
+		if opts.Snapshot, err = snapshot.New(j.ID(), title); err != nil {
+			return err
+		}
+		run, err := history.Begin(j.ID(), title, operator, manifest)
+		if err != nil {
+			return err
+		}
+		run.Warning = warning
+		err = bundle.Apply(ctx, patchDir, opts, func(msg verdaccio.PatchMessage) {
+			p := float64(msg.Progress) / float64(msg.Total) * 100
+			log.Debugf("[%.2f%%] patch %s %s\n", p, msg.Pkg, msg.PatchResult)
+
+			result := job.Result{Pkg: msg.Pkg, Result: msg.PatchResult, Error: msg.Error, Warning: msg.Warning}
+			if msg.Change != nil {
+				result.Detail = msg.Change
+				if msg.PatchResult != "fail" {
+					run.Record(*msg.Change)
+				}
+			}
+			if msg.PatchResult == "fail" {
+				run.RecordFailure()
+			}
+			j.Report(result)
+		})
+		if e := opts.Snapshot.Finish(); e != nil {
+			log.Errorf("保存快照失败 %s: %v", j.ID(), e)
+		}
+		if e := run.Finish(err); e != nil {
+			log.Errorf("保存 patch 历史失败 %s: %v", j.ID(), e)
+		}
+		return err
+	}, cleanups...)
+}
+
+// submitRollbackJob 提交回滚快照的任务
+func submitRollbackJob(s *snapshot.Snapshot) (*job.Job, error) {
+	return job.Submit("rollback", "回滚 "+s.Source, func(ctx context.Context, j *job.Job) error {
+		j.SetTotal(int64(len(s.Packages)))
+		err := snapshot.Rollback(ctx, s.ID, func(name string, err error) {
+			result := job.Result{Pkg: name, Result: "success"}
+			if err != nil {
+				result.Result, result.Error = "fail", err.Error()
+			}
+			j.Report(result)
+		})
+		if err != nil {
+			return err
+		}
+		return history.MarkRolledBack(s.ID)
+	})
+}
+
+// submitRewriteJob 提交替换 storage 中所有包 registry 地址的任务，dryRun 为 true 时只生成替换明细
+func submitRewriteJob(dryRun bool) (*job.Job, error) {
+	rewriter := config.Get().Rewriter(start.ExplicitRegistry())
+	if rewriter == nil {
+		return nil, errors.New("未配置 rewrite.target 且未指定 -registry，没有可替换的目标地址")
+	}
+	title := "替换 registry 地址"
+	if dryRun {
+		title += "（预览）"
+	}
+	return job.Submit("rewrite", title, func(ctx context.Context, j *job.Job) error {
+		var snap *snapshot.Snapshot
+		if !dryRun {
+			var err error
+			if snap, err = snapshot.New(j.ID(), title); err != nil {
+				return err
+			}
+		}
+
+		channel := make(chan verdaccio.RewriteMessage)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for msg := range channel {
+				result := job.Result{Pkg: msg.Pkg, Result: msg.RewriteResult, Error: msg.Error}
+				if len(msg.Changes) > 0 {
+					result.Detail = msg.Changes
+				}
+				j.Report(result)
+			}
+		}()
+
+		opts := workerOptions()
+		opts.OnStart = j.SetTotal
+		err := verdaccio.RewriteStorage(ctx, rewriter, dryRun, snap, opts, channel)
+		<-done
+		if e := snap.Finish(); e != nil {
+			log.Errorf("保存快照失败 %s: %v", j.ID(), e)
+		}
+		return err
+	})
+}
+
+// adjustTitle 整理任务的默认标题
+func adjustTitle(packages []string) string {
+	if len(packages) > 0 {
+		return fmt.Sprintf("整理 %d 个包", len(packages))
+	}
+	return "整理 storage"
+}
+
+// submitAdjustJob 提交整理 storage 的任务。packages 不为空时只整理这些包（如预览后确认的包、指定的包或 scope），
+// dryRun 为 true 时只生成修改明细，不写入文件；否则修改前记录快照，可通过任务 ID 回滚
+func submitAdjustJob(title string, dryRun bool, packages []string) (*job.Job, error) {
+	if dryRun {
+		title += "（预览）"
+	}
+	return job.Submit("adjust", title, func(ctx context.Context, j *job.Job) error {
+		var snap *snapshot.Snapshot
+		if !dryRun {
+			var err error
+			if snap, err = snapshot.New(j.ID(), title); err != nil {
+				return err
+			}
+		}
+
+		channel := make(chan verdaccio.AjustMessage)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for msg := range channel {
+				p := float64(msg.Progress) / float64(msg.Total) * 100
+				log.Debugf("[%.2f%%] adjust %s %s\n", p, msg.Pkg, msg.AdjustResult)
+
+				result := job.Result{Pkg: msg.Pkg, Result: msg.AdjustResult, Error: msg.Error}
+				if msg.Change != nil {
+					result.Detail = msg.Change
+				}
+				j.Report(result)
+			}
+		}()
+
+		opts := workerOptions()
+		opts.OnStart = j.SetTotal
+		err := verdaccio.AdjustStorage(ctx, opts, config.Get().Normalizer(), packages, dryRun, snap, channel)
+		<-done
+		if e := snap.Finish(); e != nil {
+			log.Errorf("保存快照失败 %s: %v", j.ID(), e)
+		}
+		return err
+	})
+}
@@ -9,7 +9,35 @@ func Register(api fiber.Router) {
 
 	storage.Post("/upload", UploadHandler)
 	storage.Post("/patch", PatchHandler)
+	storage.Post("/uploads", InitUploadHandler)
+	storage.Get("/uploads/:id", GetUploadHandler)
+	storage.Put("/uploads/:id/chunks/:index", UploadChunkHandler)
+	storage.Post("/uploads/:id/complete", CompleteUploadHandler)
+	storage.Post("/uploads/:id/preview", PreviewUploadHandler)
+	storage.Post("/uploads/:id/policy", CheckPolicyHandler)
+	storage.Delete("/uploads/:id", AbortUploadHandler)
+	storage.Get("/previews", ListPreviewsHandler)
+	storage.Get("/previews/:id", GetPreviewHandler)
+	storage.Get("/previews/:id/download", DownloadPreviewHandler)
+	storage.Post("/previews/:id/apply", ApplyPreviewHandler)
+	storage.Delete("/previews/:id", DiscardPreviewHandler)
+	storage.Get("/snapshots", ListSnapshotsHandler)
+	storage.Get("/snapshots/:id", GetSnapshotHandler)
+	storage.Post("/snapshots/:id/rollback", RollbackSnapshotHandler)
+	storage.Get("/history", ListHistoryHandler)
+	storage.Get("/history/:id", GetHistoryHandler)
+	storage.Post("/tarballs", IngestTarballsHandler)
+	storage.Post("/import", ImportHandler)
 	storage.Get("/adjust", AdjustStorageHandler)
+	storage.Post("/adjust", StartAdjustHandler)
+	storage.Post("/adjust/packages/+", AdjustPackageHandler)
+	storage.Post("/adjust/scopes/:scope", AdjustScopeHandler)
+	storage.Post("/rewrite", RewriteStorageHandler)
 	storage.Get("/packages", ListStoragePackagesHandler)
 	storage.Get("/packages/+", GetStoragePackageHandler)
+	storage.Get("/upstream", UpstreamReportHandler)
+	storage.Get("/wishlist", ListWishlistHandler)
+	storage.Post("/wishlist", AddWishesHandler)
+	storage.Delete("/wishlist", RemoveWishesHandler)
+	storage.Get("/wishlist/export", ExportWishlistHandler)
 }
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"path/filepath"
+	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/config"
+	"verda/pkg/upload"
+	"verda/pkg/verdaccio"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// CheckPolicyHandler 按准入策略检查上传会话中的补丁包，不写入 storage，检查后保留上传会话，可继续提交或预览
+func CheckPolicyHandler(ctx *fiber.Ctx) error {
+	s, err := upload.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	if err = upload.Acquire(s.ID); err != nil {
+		return err
+	}
+	defer upload.Release(s.ID)
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	outputFilePath := filepath.Join(ws.Dir, s.Filename)
+	if err = s.Merge(outputFilePath); err != nil {
+		return errors.WithMessage(err, "文件合并失败")
+	}
+	patchDir, err := bundle.Open(ws, outputFilePath)
+	if err != nil {
+		return err
+	}
+
+	policy := config.Get().AdmissionPolicy()
+	result, err := verdaccio.CheckPolicy(patchDir, policy)
+	if err != nil {
+		return errors.WithMessage(err, "检查准入策略失败")
+	}
+	return ctx.JSON(response.Success(fiber.Map{
+		"policy":   policy,
+		"packages": result,
+	}, ctx))
+}
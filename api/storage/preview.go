@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/config"
+	"verda/pkg/history"
+	"verda/pkg/job"
+	"verda/pkg/upload"
+	"verda/start"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+	"github.com/tidwall/pretty"
+)
+
+// PreviewUploadHandler 提交生成预览的任务，返回任务信息：在后台合并上传会话的分片并解压到临时目录，
+// 生成变更报告但不写入 storage，变更报告保存在任务的 detail 中
+func PreviewUploadHandler(ctx *fiber.Ctx) error {
+	s, err := upload.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	if err = upload.Acquire(s.ID); err != nil {
+		return err
+	}
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		upload.Release(s.ID)
+		return err
+	}
+
+	j, err := submitPreviewJob(s.Filename, ws, func(ctx context.Context) (string, error) {
+		outputFilePath := filepath.Join(ws.Dir, s.Filename)
+		if err := s.Merge(outputFilePath); err != nil {
+			return "", errors.WithMessage(err, "文件合并失败")
+		}
+		if err := upload.Remove(s.ID); err != nil {
+			log.Errorf("删除上传会话失败 %s: %v", s.ID, err)
+		}
+		return outputFilePath, nil
+	}, func() { upload.Release(s.ID) })
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
+
+// submitPreviewJob 提交生成预览的任务。prepare 在任务中准备补丁包（如合并分片），返回补丁包路径，之后解压并生成变更报告，
+// 变更报告保存在任务的 detail 中。预览生成后工作目录由预览管理，生成失败或任务在排队时被取消则删除工作目录
+func submitPreviewJob(source string, ws *upload.Workspace, prepare func(ctx context.Context) (string, error), cleanups ...func()) (*job.Job, error) {
+	var created bool
+	cleanups = append(cleanups, func() {
+		if !created {
+			ws.Close()
+		}
+	})
+	return job.Submit("preview", "预览 "+source, func(ctx context.Context, j *job.Job) error {
+		bundlePath, err := prepare(ctx)
+		if err != nil {
+			return err
+		}
+		preview, err := newPreview(ws, bundlePath, source)
+		if err != nil {
+			return err
+		}
+		created = true
+		j.SetDetail(preview)
+		return nil
+	}, cleanups...)
+}
+
+// newPreview 打开补丁包并生成预览，失败时删除工作目录
+func newPreview(ws *upload.Workspace, bundlePath, source string) (*bundle.Preview, error) {
+	patchDir, err := bundle.Open(ws, bundlePath)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	opts, err := patchOptions()
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	preview, err := bundle.NewPreview(ws, patchDir, source, *start.UploadTTL, opts)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if config.Get().SequenceAction() != "" {
+		if e, err := history.CheckSequence(preview.Manifest); err != nil {
+			log.Errorf("检查补丁包序号失败 %s: %v", source, err)
+		} else if e != nil {
+			preview.SequenceWarning = e.Error()
+		}
+	}
+	return preview, nil
+}
+
+// ListPreviewsHandler 获取所有未过期的预览
+func ListPreviewsHandler(ctx *fiber.Ctx) error {
+	return ctx.JSON(response.Success(bundle.ListPreviews(), ctx))
+}
+
+// GetPreviewHandler 获取预览的变更报告
+func GetPreviewHandler(ctx *fiber.Ctx) error {
+	preview, err := bundle.GetPreview(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(preview, ctx))
+}
+
+// DownloadPreviewHandler 下载预览的变更报告
+func DownloadPreviewHandler(ctx *fiber.Ctx) error {
+	preview, err := bundle.GetPreview(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(preview)
+	if err != nil {
+		return errors.Wrap(err, "序列化变更报告失败")
+	}
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	ctx.Attachment("patch-report-" + preview.ID + ".json")
+	return ctx.Send(pretty.Pretty(content))
+}
+
+// ApplyPreviewHandler 确认预览后提交将补丁合并到 storage 的任务
+func ApplyPreviewHandler(ctx *fiber.Ctx) error {
+	preview, err := bundle.TakePreview(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	j, err := submitPatchJob(preview.Source, operator(ctx), ctx.QueryBool("force"), func(ctx context.Context) (string, error) {
+		return preview.PatchDir(), nil
+	}, func() { preview.Close() })
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
+
+// DiscardPreviewHandler 放弃预览并删除临时目录
+func DiscardPreviewHandler(ctx *fiber.Ctx) error {
+	if err := bundle.DiscardPreview(ctx.Params("id")); err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(true, ctx))
+}
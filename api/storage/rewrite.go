@@ -0,0 +1,27 @@
+package storage
+
+import (
+	response "verda/pkg"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RewriteVO struct {
+	DryRun bool `json:"dryRun" form:"dryRun"`
+}
+
+// RewriteStorageHandler 提交替换 storage 中所有包 registry 地址的任务，dryRun 为 true 时只生成替换明细，不写入文件
+func RewriteStorageHandler(ctx *fiber.Ctx) error {
+	p := new(RewriteVO)
+	// 请求体可以为空，此时直接替换
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(p); err != nil {
+			return err
+		}
+	}
+	j, err := submitRewriteJob(p.DryRun)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	response "verda/pkg"
+	"verda/pkg/snapshot"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// ListSnapshotsHandler 获取所有 patch 快照
+func ListSnapshotsHandler(ctx *fiber.Ctx) error {
+	list, err := snapshot.List()
+	if err != nil {
+		return errors.WithMessage(err, "获取快照列表失败")
+	}
+	return ctx.JSON(response.Success(list, ctx))
+}
+
+// GetSnapshotHandler 获取快照中记录的包及新增的文件
+func GetSnapshotHandler(ctx *fiber.Ctx) error {
+	s, err := snapshot.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(s, ctx))
+}
+
+// RollbackSnapshotHandler 提交将 storage 回滚到快照对应的 patch 之前的任务
+func RollbackSnapshotHandler(ctx *fiber.Ctx) error {
+	s, err := snapshot.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	if s.RolledBackAt != nil {
+		return errors.New("快照已回滚：" + s.ID)
+	}
+	j, err := submitRollbackJob(s)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
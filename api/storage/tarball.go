@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/job"
+	"verda/pkg/upload"
+	"verda/pkg/verdaccio"
+	"verda/start"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+type IngestResultVO struct {
+	File     string `json:"file"`
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type IngestVO struct {
+	Files []IngestResultVO `json:"files"`
+	// 没有可导入的 tgz 文件时为空
+	Job *job.Status `json:"job"`
+}
+
+// IngestTarballsHandler 导入一个或多个 npm pack 生成的 tgz 文件：整理为补丁目录后提交打补丁任务，
+// 与补丁包一样经过冲突检测、准入策略、保护检查，并记录快照和 patch 历史。返回每个文件的解析结果及任务信息
+func IngestTarballsHandler(ctx *fiber.Ctx) error {
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		return errors.Wrap(err, "参数解析错误")
+	}
+	files := form.File["files"]
+	if len(files) == 0 {
+		return errors.New("请选择要导入的 tgz 文件")
+	}
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		return err
+	}
+	tarballDir := filepath.Join(ws.Dir, "tarballs")
+	if err = os.MkdirAll(tarballDir, os.ModePerm); err != nil {
+		ws.Close()
+		return errors.Wrap(err, "无法创建临时目录")
+	}
+
+	vo := IngestVO{Files: make([]IngestResultVO, 0, len(files))}
+	tarballs := make([]*verdaccio.Tarball, 0, len(files))
+	for i, file := range files {
+		result := IngestResultVO{File: file.Filename}
+		// 加上序号，避免同名文件互相覆盖
+		tarballPath := filepath.Join(tarballDir, fmt.Sprintf("%d-%s", i, filepath.Base(file.Filename)))
+		if err = ctx.SaveFile(file, tarballPath); err != nil {
+			result.Error = errors.Wrapf(err, "保存文件失败：%s", file.Filename).Error()
+			vo.Files = append(vo.Files, result)
+			continue
+		}
+		t, err := verdaccio.ReadTarball(tarballPath)
+		if err != nil {
+			log.Errorf("读取 tarball 失败 %s: %v", file.Filename, err)
+			result.Error = err.Error()
+		} else {
+			result.Name, result.Version, result.Filename = t.Name, t.Version, verdaccio.DistFilename(t.Name, t.Version)
+			tarballs = append(tarballs, t)
+		}
+		vo.Files = append(vo.Files, result)
+	}
+	if len(tarballs) == 0 {
+		ws.Close()
+		return ctx.JSON(response.Success(vo, ctx))
+	}
+
+	title := fmt.Sprintf("导入 %d 个 tgz 文件", len(tarballs))
+	j, err := submitPatchJob(title, operator(ctx), false, func(ctx context.Context) (string, error) {
+		// 在任务中整理补丁目录，保证读取到的 latest 是 storage 中最新的
+		patchDir := filepath.Join(ws.Dir, bundle.PatchDirname)
+		return patchDir, verdaccio.StageTarballs(tarballs, *start.Registry, patchDir)
+	}, func() { ws.Close() })
+	if err != nil {
+		return err
+	}
+	status := j.Status()
+	vo.Job = &status
+	return ctx.JSON(response.Success(vo, ctx))
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	response "verda/pkg"
+	"verda/pkg/bundle"
+	"verda/pkg/upload"
+	"verda/pkg/verdaccio"
+	"verda/start"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+type InitUploadVO struct {
+	Filename   string `json:"filename" form:"filename"`
+	Size       int64  `json:"size" form:"size"`
+	ChunkSize  int64  `json:"chunkSize" form:"chunkSize"`
+	ChunkCount int    `json:"chunkCount" form:"chunkCount"`
+	MD5        string `json:"md5" form:"md5"`
+}
+
+type UploadSessionVO struct {
+	*upload.Session
+	Uploaded []int `json:"uploaded"`
+}
+
+func sessionVO(s *upload.Session) (*UploadSessionVO, error) {
+	uploaded, err := s.Uploaded()
+	if err != nil {
+		return nil, err
+	}
+	return &UploadSessionVO{Session: s, Uploaded: uploaded}, nil
+}
+
+// uploadLimits 根据启动参数获取上传限额
+func uploadLimits() (*upload.Limits, error) {
+	maxSize, err := utils.ParseSize(*start.UploadMaxSize)
+	if err != nil {
+		return nil, errors.WithMessage(err, "-upload-max-size 参数错误")
+	}
+	maxInflight, err := utils.ParseSize(*start.UploadMaxInflight)
+	if err != nil {
+		return nil, errors.WithMessage(err, "-upload-max-inflight 参数错误")
+	}
+	storagePath, err := verdaccio.GetStoragePath()
+	if err != nil {
+		return nil, errors.WithMessage(err, "获取 storage 路径失败")
+	}
+	return &upload.Limits{
+		MaxSize:         maxSize,
+		MaxInflight:     maxInflight,
+		ExpansionFactor: *start.ExpansionFactor,
+		StoragePath:     storagePath,
+	}, nil
+}
+
+// InitUploadHandler 创建上传会话，相同文件重复创建时返回已有会话及已上传的分片，用于断点续传
+func InitUploadHandler(ctx *fiber.Ctx) error {
+	p := new(InitUploadVO)
+	if err := ctx.BodyParser(p); err != nil {
+		return errors.Wrap(err, "参数解析错误")
+	}
+
+	limits, err := uploadLimits()
+	if err != nil {
+		return err
+	}
+
+	s, err := upload.Init(p.Filename, p.Size, p.ChunkSize, p.ChunkCount, p.MD5, *limits)
+	if err != nil {
+		return errors.WithMessage(err, "创建上传会话失败")
+	}
+
+	vo, err := sessionVO(s)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(vo, ctx))
+}
+
+// GetUploadHandler 获取上传会话及已上传的分片序号
+func GetUploadHandler(ctx *fiber.Ctx) error {
+	s, err := upload.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	vo, err := sessionVO(s)
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(vo, ctx))
+}
+
+// UploadChunkHandler 上传会话中的某个分片，分片可以按任意顺序、重复上传
+func UploadChunkHandler(ctx *fiber.Ctx) error {
+	s, err := upload.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	index, err := strconv.Atoi(ctx.Params("index"))
+	if err != nil {
+		return errors.Wrap(err, "分片序号错误")
+	}
+
+	chunkFile, err := ctx.FormFile("chunkFile")
+	if err != nil {
+		return errors.Wrap(err, "无法获取 chunkFile")
+	}
+	file, err := chunkFile.Open()
+	if err != nil {
+		return errors.Wrap(err, "无法读取 chunkFile")
+	}
+	defer file.Close()
+
+	if err = s.SaveChunk(index, file); err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(fiber.Map{
+		"index": index,
+	}, ctx))
+}
+
+// CompleteUploadHandler 所有分片上传完成后提交合并文件并打补丁的任务
+func CompleteUploadHandler(ctx *fiber.Ctx) error {
+	s, err := upload.Get(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	if err = upload.Acquire(s.ID); err != nil {
+		return err
+	}
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		upload.Release(s.ID)
+		return err
+	}
+
+	// 合并、解压都在后台任务中进行
+	j, err := submitPatchJob(s.Filename, operator(ctx), ctx.QueryBool("force"), func(ctx context.Context) (string, error) {
+		outputFilePath := filepath.Join(ws.Dir, s.Filename)
+		if err := s.Merge(outputFilePath); err != nil {
+			return "", errors.WithMessage(err, "文件合并失败")
+		}
+		if err := upload.Remove(s.ID); err != nil {
+			log.Errorf("删除上传会话失败 %s: %v", s.ID, err)
+		}
+		return bundle.Open(ws, outputFilePath)
+	}, func() {
+		ws.Close()
+		upload.Release(s.ID)
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(j.Status(), ctx))
+}
+
+// AbortUploadHandler 放弃上传会话并删除已上传的分片
+func AbortUploadHandler(ctx *fiber.Ctx) error {
+	if err := upload.Remove(ctx.Params("id")); err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(true, ctx))
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	response "verda/pkg"
+	"verda/pkg/upstream"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/tidwall/pretty"
+)
+
+type WishVO struct {
+	Name string `json:"name"`
+	// 为空时移除该包的所有版本，仅用于移除
+	Versions []string `json:"versions"`
+}
+
+// UpstreamReportHandler 获取 storage 中所有上游存在但未同步的版本
+func UpstreamReportHandler(ctx *fiber.Ctx) error {
+	list, err := upstream.List()
+	if err != nil {
+		return errors.WithMessage(err, "获取上游版本失败")
+	}
+	versions := 0
+	for _, p := range list {
+		versions += len(p.Versions)
+	}
+	return ctx.JSON(response.Success(fiber.Map{
+		"packages": list,
+		"total":    len(list),
+		"versions": versions,
+	}, ctx))
+}
+
+// ListWishlistHandler 获取心愿单
+func ListWishlistHandler(ctx *fiber.Ctx) error {
+	wishes, err := upstream.Wishlist()
+	if err != nil {
+		return errors.WithMessage(err, "获取心愿单失败")
+	}
+	return ctx.JSON(response.Success(wishes, ctx))
+}
+
+// AddWishesHandler 将上游存在但未同步的版本加入心愿单，返回新加入的版本
+func AddWishesHandler(ctx *fiber.Ctx) error {
+	p := new(WishVO)
+	if err := ctx.BodyParser(p); err != nil {
+		return err
+	}
+	if strings.TrimSpace(p.Name) == "" || len(p.Versions) == 0 {
+		return errors.New("包名和版本不能为空")
+	}
+	added, err := upstream.AddWishes(p.Name, p.Versions, operator(ctx))
+	if err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(added, ctx))
+}
+
+// RemoveWishesHandler 从心愿单中移除版本
+func RemoveWishesHandler(ctx *fiber.Ctx) error {
+	p := new(WishVO)
+	if err := ctx.BodyParser(p); err != nil {
+		return err
+	}
+	if strings.TrimSpace(p.Name) == "" {
+		return errors.New("包名不能为空")
+	}
+	if err := upstream.RemoveWishes(p.Name, p.Versions); err != nil {
+		return err
+	}
+	return ctx.JSON(response.Success(true, ctx))
+}
+
+// ExportWishlistHandler 下载心愿单清单，在外网据此获取 tarball 并生成补丁包
+func ExportWishlistHandler(ctx *fiber.Ctx) error {
+	m, err := upstream.Export()
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "序列化心愿单失败")
+	}
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	ctx.Attachment(upstream.ManifestFile)
+	return ctx.Send(pretty.Pretty(content))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"verda/pkg/bundle"
+	"verda/pkg/config"
+	"verda/pkg/history"
+	"verda/pkg/job"
+	"verda/pkg/snapshot"
+	"verda/pkg/upload"
+	"verda/pkg/upstream"
+	"verda/pkg/verdaccio"
+	"verda/start"
+	"verda/utils"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// importBundle 命令行方式从服务器路径导入补丁包。与接口一样只允许导入 -import-roots 中的路径；
+// 导入期间持有 storage 锁，服务正在执行任务时拒绝导入，服务的任务也会等待导入结束
+func importBundle(path string) error {
+	if strings.TrimSpace(*start.ImportRoots) == "" {
+		return errors.New("-import 需要通过 -import-roots 指定允许导入的目录")
+	}
+	path, err := bundle.AllowedPath(path, strings.Split(*start.ImportRoots, ","))
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(*start.DataDir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建数据目录：%s", *start.DataDir)
+	}
+	lockFile := filepath.Join(*start.DataDir, job.LockFile)
+	unlock, err := utils.TryLock(lockFile)
+	if errors.Is(err, utils.ErrLocked) {
+		return errors.Errorf("verda 服务正在执行任务（%s 已被锁定），请等待任务结束后重试，或通过 /api/storage/import 导入", lockFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	ws, err := upload.NewWorkspace()
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	patchDir, err := bundle.Open(ws, path)
+	if err != nil {
+		return err
+	}
+
+	limit, err := utils.ParseSize(*start.IOLimit)
+	if err != nil {
+		return errors.WithMessage(err, "-io-limit 参数错误")
+	}
+	opts := verdaccio.PatchOptions{
+		WorkerOptions: verdaccio.WorkerOptions{
+			Concurrency: *start.Concurrency,
+			Limiter:     utils.NewRateLimiter(limit),
+		},
+		ConflictPolicy: verdaccio.ConflictPolicy(*start.ConflictPolicy),
+		QuarantineDir:  filepath.Join(*start.DataDir, "quarantine"),
+	}
+	c := config.Get()
+	if !c.Rewrite.Disabled {
+		if opts.Rewriter = c.Rewriter(start.ExplicitRegistry()); opts.Rewriter == nil {
+			fmt.Println("未配置 rewrite.target 且未指定 -registry，跳过 registry 地址替换")
+		}
+	}
+	opts.Normalizer = c.Normalizer()
+	opts.Policy = c.AdmissionPolicy()
+	opts.Renamer = c.Renamer()
+	if opts.Protection, err = c.Protection(); err != nil {
+		return errors.WithMessage(err, "读取受保护的包失败")
+	}
+
+	manifest, err := bundle.Verify(patchDir, opts.Limiter)
+	if err != nil {
+		return err
+	}
+	if manifest != nil {
+		fmt.Printf("补丁包 #%d，由 %s 于 %s 从 %s 生成\n", manifest.Sequence, manifest.Creator,
+			manifest.CreatedAt.Format(time.DateTime), manifest.SourceRegistry)
+	}
+
+	if err = snapshot.Init(filepath.Join(*start.DataDir, "snapshots")); err != nil {
+		return err
+	}
+	if err = history.Init(filepath.Join(*start.DataDir, "history")); err != nil {
+		return err
+	}
+	if err = upstream.Init(filepath.Join(*start.DataDir, "upstream")); err != nil {
+		return err
+	}
+	var warning string
+	if action := c.SequenceAction(); action != "" {
+		if warning, err = history.EnforceSequence(manifest, action == history.SequenceRefuse && !*start.Force); err != nil {
+			return err
+		}
+		if warning != "" {
+			fmt.Printf("警告：%s\n", warning)
+		}
+	}
+	id := uuid.NewString()
+	if opts.Snapshot, err = snapshot.New(id, path); err != nil {
+		return err
+	}
+	run, err := history.Begin(id, path, *start.Operator, manifest)
+	if err != nil {
+		return err
+	}
+	run.Warning = warning
+
+	fmt.Printf("开始导入 %s\n", patchDir)
+	err = bundle.Apply(context.Background(), patchDir, opts, func(msg verdaccio.PatchMessage) {
+		p := float64(msg.Progress) / float64(msg.Total) * 100
+		fmt.Printf("[%6.2f%%] %d/%d %s %s\n", p, msg.Progress, msg.Total, msg.Pkg, msg.PatchResult)
+		if msg.Warning != "" {
+			fmt.Printf("警告：%s\n", msg.Warning)
+		}
+		if msg.PatchResult == "fail" {
+			run.RecordFailure()
+		} else if msg.Change != nil {
+			run.Record(*msg.Change)
+		}
+	})
+	if e := opts.Snapshot.Finish(); e != nil {
+		fmt.Printf("保存快照失败：%v\n", e)
+	}
+	if e := run.Finish(err); e != nil {
+		fmt.Printf("保存 patch 历史失败：%v\n", e)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "导入 %s 失败，可通过快照 %s 回滚", path, opts.Snapshot.ID)
+	}
+	fmt.Println("导入完成")
+	return nil
+}
+
+// makeManifest 命令行方式为补丁包目录生成清单
+func makeManifest(dir string) error {
+	m, err := bundle.GenerateManifest(dir, *start.ManifestSource, *start.ManifestCreator, *start.ManifestSequence, *start.ManifestPrevious)
+	if err != nil {
+		return errors.WithMessagef(err, "生成清单失败：%s", dir)
+	}
+	var count int
+	for _, pkg := range m.Packages {
+		count += len(pkg.Tarballs)
+	}
+	fmt.Printf("已生成 %s：%d 个包，%d 个 tarball，序号 %d，sha256 %s\n", filepath.Join(dir, bundle.ManifestFile), len(m.Packages), count, m.Sequence, m.Hash)
+	return nil
+}
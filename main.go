@@ -1,17 +1,51 @@
 package main
 
 import (
+	"path/filepath"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/log"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/pkg/errors"
 	"verda/api"
 	"verda/middleware"
 	response "verda/pkg"
+	"verda/pkg/config"
+	"verda/pkg/history"
+	"verda/pkg/job"
+	"verda/pkg/snapshot"
+	"verda/pkg/upload"
+	"verda/pkg/upstream"
+	"verda/pkg/verdaccio"
 	"verda/start"
+	"verda/utils"
 )
 
 func main() {
+	if err := config.Load(*start.Config); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := verdaccio.ParseConflictPolicy(*start.ConflictPolicy); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := utils.ParseSize(*start.IOLimit); err != nil {
+		log.Fatal(errors.WithMessage(err, "-io-limit 参数错误"))
+	}
+
+	if *start.MakeManifest != "" {
+		if err := makeManifest(*start.MakeManifest); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *start.Import != "" {
+		if err := importBundle(*start.Import); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	app := fiber.New(fiber.Config{
 		AppName:   "Verda",
 		BodyLimit: 50 * 1024 * 1024,
@@ -26,6 +60,20 @@ func main() {
 	// 恐慌恢复 😱 中间件，防止程序崩溃宕机
 	app.Use(recover.New())
 
+	if err := job.Init(filepath.Join(*start.DataDir, "jobs"), filepath.Join(*start.DataDir, job.LockFile)); err != nil {
+		log.Fatal(err)
+	}
+	if err := snapshot.Init(filepath.Join(*start.DataDir, "snapshots")); err != nil {
+		log.Fatal(err)
+	}
+	if err := history.Init(filepath.Join(*start.DataDir, "history")); err != nil {
+		log.Fatal(err)
+	}
+	if err := upstream.Init(filepath.Join(*start.DataDir, "upstream")); err != nil {
+		log.Fatal(err)
+	}
+	upload.StartJanitor(*start.UploadTTL, *start.GCInterval)
+
 	api.Register(app)
 	middleware.Static(app)
 
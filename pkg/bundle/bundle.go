@@ -0,0 +1,96 @@
+package bundle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"verda/pkg/upload"
+	"verda/pkg/verdaccio"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+)
+
+// PatchDirname 补丁包中存放待合并包的目录名
+const PatchDirname = "storage-patch"
+
+// Resolve 获取补丁目录：存在 storage-patch 子目录时使用该子目录，否则使用 dir 本身
+func Resolve(dir string) string {
+	patchDir := filepath.Join(dir, PatchDirname)
+	if utils.IsDir(patchDir) {
+		return patchDir
+	}
+	return dir
+}
+
+// Open 打开补丁包，压缩包会被解压到工作目录 ws 中，已解压的文件夹则直接使用，返回补丁目录
+func Open(ws *upload.Workspace, path string) (string, error) {
+	if !utils.PathExists(path) {
+		return "", errors.New("路径不存在：" + path)
+	}
+	if utils.IsDir(path) {
+		return Resolve(path), nil
+	}
+
+	extractDir := filepath.Join(ws.Dir, "extract")
+	if err := utils.Extract(path, extractDir); err != nil {
+		return "", errors.WithMessage(err, "解压失败")
+	}
+	return Resolve(extractDir), nil
+}
+
+// AllowedPath 校验 path 是否位于允许导入的根目录之一下（会解析符号链接），返回解析后的绝对路径
+func AllowedPath(path string, roots []string) (string, error) {
+	if len(roots) == 0 {
+		return "", errors.New("未配置允许导入的目录（-import-roots），无法从服务器路径导入")
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "无法解析路径：%s", path)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", errors.Wrapf(err, "无法解析路径：%s", path)
+	}
+	for _, root := range roots {
+		root = strings.TrimSpace(root)
+		if root == "" {
+			continue
+		}
+		rootAbs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if r, err := filepath.EvalSymlinks(rootAbs); err == nil {
+			rootAbs = r
+		}
+		rel, err := filepath.Rel(rootAbs, resolved)
+		if err != nil {
+			continue
+		}
+		if rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))) {
+			return resolved, nil
+		}
+	}
+	return "", errors.New("路径不在允许导入的目录中：" + path)
+}
+
+// Apply 将补丁目录合并到 verdaccio storage，每处理完一个包回调一次 progress
+func Apply(ctx context.Context, patchDir string, opts verdaccio.PatchOptions, progress func(msg verdaccio.PatchMessage)) error {
+	channel := make(chan verdaccio.PatchMessage)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range channel {
+			progress(msg)
+		}
+	}()
+
+	err := verdaccio.PatchStorage(ctx, patchDir, opts, channel)
+	<-done
+	if err != nil {
+		return errors.WithMessage(err, "打补丁失败")
+	}
+	return nil
+}
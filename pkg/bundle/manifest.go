@@ -0,0 +1,284 @@
+package bundle
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"verda/pkg/verdaccio"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// ManifestFile 补丁包清单的文件名，位于补丁包根目录（与 storage-patch 同级）
+const ManifestFile = "verda-bundle.json"
+
+// ManifestFormatVersion 当前支持的清单格式版本
+const ManifestFormatVersion = 1
+
+// Manifest 补丁包清单，记录补丁包的来源及包含的所有 tarball
+type Manifest struct {
+	FormatVersion int `json:"formatVersion"`
+	// 生成补丁包时使用的外网 registry
+	SourceRegistry string    `json:"sourceRegistry"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Creator        string    `json:"creator"`
+	// 补丁包序号，同一来源的补丁包依次递增
+	Sequence int64 `json:"sequence"`
+	// 上一个补丁包清单的 sha256，用于检查补丁包链是否完整，第一个补丁包为空
+	PreviousHash string            `json:"previousHash,omitempty"`
+	Packages     []ManifestPackage `json:"packages"`
+	// 清单文件的 sha256，读取时计算，不写入清单
+	Hash string `json:"hash,omitempty"`
+}
+
+type ManifestPackage struct {
+	Name     string            `json:"name"`
+	Tarballs []ManifestTarball `json:"tarballs"`
+}
+
+type ManifestTarball struct {
+	Version   string `json:"version"`
+	Filename  string `json:"filename"`
+	Shasum    string `json:"shasum"`
+	Integrity string `json:"integrity"`
+}
+
+// ChecksumMismatch 文件校验和与清单不一致
+type ChecksumMismatch struct {
+	File     string `json:"file"`
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// VerifyError 补丁包内容与清单不一致
+type VerifyError struct {
+	// 清单中有但补丁包中不存在的文件
+	Missing []string `json:"missing"`
+	// 补丁包中有但清单中未列出的文件
+	Extra      []string           `json:"extra"`
+	Mismatches []ChecksumMismatch `json:"mismatches"`
+}
+
+func (e *VerifyError) Error() string {
+	parts := make([]string, 0, 3)
+	if len(e.Missing) > 0 {
+		parts = append(parts, fmt.Sprintf("缺少 %d 个文件（%s）", len(e.Missing), brief(e.Missing)))
+	}
+	if len(e.Extra) > 0 {
+		parts = append(parts, fmt.Sprintf("多出 %d 个文件（%s）", len(e.Extra), brief(e.Extra)))
+	}
+	if len(e.Mismatches) > 0 {
+		files := make([]string, 0, len(e.Mismatches))
+		for _, m := range e.Mismatches {
+			files = append(files, m.File)
+		}
+		files = lo.Uniq(files)
+		parts = append(parts, fmt.Sprintf("%d 个文件校验和不一致（%s）", len(files), brief(files)))
+	}
+	return "补丁包与清单不一致：" + strings.Join(parts, "；")
+}
+
+// brief 最多列出前 5 项
+func brief(items []string) string {
+	if len(items) > 5 {
+		return strings.Join(items[:5], "、") + " 等"
+	}
+	return strings.Join(items, "、")
+}
+
+// manifestPath 清单位于 storage-patch 的上一级目录；补丁目录本身就是根目录时位于补丁目录中
+func manifestPath(patchDir string) string {
+	if filepath.Base(patchDir) == PatchDirname {
+		if p := filepath.Join(filepath.Dir(patchDir), ManifestFile); utils.PathExists(p) {
+			return p
+		}
+	}
+	return filepath.Join(patchDir, ManifestFile)
+}
+
+// ReadManifest 读取补丁目录对应的清单，没有清单的旧版补丁包返回 nil
+func ReadManifest(patchDir string) (*Manifest, error) {
+	path := manifestPath(patchDir)
+	if !utils.PathExists(path) {
+		return nil, nil
+	}
+	return readManifestFile(path)
+}
+
+// ReadPreviousManifest 读取上一个补丁包的清单，path 为补丁包根目录、补丁目录或清单文件
+func ReadPreviousManifest(path string) (*Manifest, error) {
+	if utils.IsDir(path) {
+		path = manifestPath(Resolve(path))
+	}
+	if !utils.PathExists(path) {
+		return nil, errors.Errorf("上一个补丁包的清单不存在：%s", path)
+	}
+	return readManifestFile(path)
+}
+
+func readManifestFile(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法读取补丁包清单：%s", ManifestFile)
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(content, m); err != nil {
+		return nil, errors.Wrapf(err, "无法解析补丁包清单：%s", ManifestFile)
+	}
+	if m.FormatVersion < 1 || m.FormatVersion > ManifestFormatVersion {
+		return nil, errors.Errorf("不支持的补丁包清单版本：%d", m.FormatVersion)
+	}
+	sum := sha256.Sum256(content)
+	m.Hash = hex.EncodeToString(sum[:])
+	return m, nil
+}
+
+// Verify 按清单校验补丁目录：缺少或多出的 tarball、校验和不一致。
+// 没有清单的旧版补丁包不校验，返回 nil；校验失败时返回 *VerifyError
+func Verify(patchDir string, limiter *utils.RateLimiter) (*Manifest, error) {
+	m, err := ReadManifest(patchDir)
+	if err != nil || m == nil {
+		return nil, err
+	}
+
+	names, err := verdaccio.ListPackageDirs(patchDir)
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]bool)
+	for _, name := range names {
+		files, err := verdaccio.GetLocalDistFiles(filepath.Join(patchDir, name))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			actual[name+"/"+file] = true
+		}
+	}
+
+	result := &VerifyError{Missing: make([]string, 0), Extra: make([]string, 0), Mismatches: make([]ChecksumMismatch, 0)}
+	for _, pkg := range m.Packages {
+		if !utils.PathExists(filepath.Join(patchDir, pkg.Name, "package.json")) {
+			result.Missing = append(result.Missing, pkg.Name+"/package.json")
+		}
+		for _, t := range pkg.Tarballs {
+			file := pkg.Name + "/" + t.Filename
+			if !actual[file] {
+				result.Missing = append(result.Missing, file)
+				continue
+			}
+			delete(actual, file)
+
+			shasum, integrity, err := checksums(filepath.Join(patchDir, pkg.Name, t.Filename), limiter)
+			if err != nil {
+				return nil, errors.Wrapf(err, "计算校验和失败：%s", file)
+			}
+			if t.Shasum != "" && !strings.EqualFold(t.Shasum, shasum) {
+				result.Mismatches = append(result.Mismatches, ChecksumMismatch{File: file, Field: "shasum", Expected: t.Shasum, Actual: shasum})
+			}
+			if t.Integrity != "" && t.Integrity != integrity {
+				result.Mismatches = append(result.Mismatches, ChecksumMismatch{File: file, Field: "integrity", Expected: t.Integrity, Actual: integrity})
+			}
+		}
+	}
+	for file := range actual {
+		result.Extra = append(result.Extra, file)
+	}
+	sort.Strings(result.Extra)
+
+	if len(result.Missing) > 0 || len(result.Extra) > 0 || len(result.Mismatches) > 0 {
+		return m, result
+	}
+	return m, nil
+}
+
+func checksums(path string, limiter *utils.RateLimiter) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	return utils.Checksums(limiter.Reader(bufio.NewReader(file)))
+}
+
+// GenerateManifest 为补丁包根目录 dir 生成清单并写入 dir/verda-bundle.json。
+// previous 为上一个补丁包（根目录或清单文件），不为空时记录其清单的哈希，sequence 为 0 时使用上一个补丁包的序号加 1
+func GenerateManifest(dir, sourceRegistry, creator string, sequence int64, previous string) (*Manifest, error) {
+	patchDir := Resolve(dir)
+	names, err := verdaccio.ListPackageDirs(patchDir)
+	if err != nil {
+		return nil, err
+	}
+	var previousHash string
+	if previous != "" {
+		prev, err := ReadPreviousManifest(previous)
+		if err != nil {
+			return nil, err
+		}
+		previousHash = prev.Hash
+		if sequence == 0 {
+			sequence = prev.Sequence + 1
+		}
+	}
+
+	m := &Manifest{
+		FormatVersion:  ManifestFormatVersion,
+		SourceRegistry: sourceRegistry,
+		CreatedAt:      time.Now(),
+		Creator:        creator,
+		Sequence:       sequence,
+		PreviousHash:   previousHash,
+		Packages:       make([]ManifestPackage, 0, len(names)),
+	}
+	for _, name := range names {
+		pkgPath := filepath.Join(patchDir, name)
+		pkg, err := verdaccio.GetPackage(pkgPath)
+		if err != nil {
+			return nil, err
+		}
+		versions := make(map[string]string, len(pkg.Versions))
+		for v := range pkg.Versions {
+			versions[verdaccio.DistFilename(name, v)] = v
+		}
+		files, err := verdaccio.GetLocalDistFiles(pkgPath)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+
+		mp := ManifestPackage{Name: name, Tarballs: make([]ManifestTarball, 0, len(files))}
+		for _, file := range files {
+			shasum, integrity, err := utils.FileChecksums(filepath.Join(pkgPath, file))
+			if err != nil {
+				return nil, errors.Wrapf(err, "计算校验和失败：%s", file)
+			}
+			version, ok := versions[file]
+			if !ok {
+				version = verdaccio.GetVersionFromDistFile(file)
+			}
+			mp.Tarballs = append(mp.Tarballs, ManifestTarball{Version: version, Filename: file, Shasum: shasum, Integrity: integrity})
+		}
+		m.Packages = append(m.Packages, mp)
+	}
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "序列化补丁包清单失败")
+	}
+	if err = utils.WriteFileAtomic(filepath.Join(dir, ManifestFile), content, 0644); err != nil {
+		return nil, errors.Wrapf(err, "写入补丁包清单失败：%s", dir)
+	}
+	sum := sha256.Sum256(content)
+	m.Hash = hex.EncodeToString(sum[:])
+	return m, nil
+}
@@ -0,0 +1,145 @@
+package bundle
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeBundle 生成包含 demo@1.0.0 的补丁包及其清单，返回补丁包根目录
+func writeBundle(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	pkgPath := filepath.Join(dir, PatchDirname, "demo")
+	if err := os.MkdirAll(pkgPath, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"package.json":   `{"name":"demo","versions":{"1.0.0":{"name":"demo","version":"1.0.0"}},"dist-tags":{"latest":"1.0.0"}}`,
+		"demo-1.0.0.tgz": "content",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(pkgPath, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := GenerateManifest(dir, "https://registry.npmjs.org/", "tester", 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// updateManifest 修改补丁包清单后写回
+func updateManifest(t *testing.T, dir string, update func(m *Manifest)) {
+	t.Helper()
+	path := filepath.Join(dir, ManifestFile)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manifest{}
+	if err = json.Unmarshal(content, m); err != nil {
+		t.Fatal(err)
+	}
+	update(m)
+	if content, err = json.Marshal(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(t *testing.T, dir string)
+		// 期望的校验结果，nil 表示校验通过
+		want    *VerifyError
+		wantErr bool
+	}{
+		{
+			name:   "与清单一致",
+			modify: func(t *testing.T, dir string) {},
+		},
+		{
+			name: "清单中的哈希被篡改",
+			modify: func(t *testing.T, dir string) {
+				updateManifest(t, dir, func(m *Manifest) {
+					m.Packages[0].Tarballs[0].Shasum = "0000000000000000000000000000000000000000"
+				})
+			},
+			want: &VerifyError{Missing: []string{}, Extra: []string{}, Mismatches: []ChecksumMismatch{{File: "demo/demo-1.0.0.tgz", Field: "shasum"}}},
+		},
+		{
+			name: "tarball 被篡改",
+			modify: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, PatchDirname, "demo", "demo-1.0.0.tgz"), []byte("tampered"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: &VerifyError{Missing: []string{}, Extra: []string{}, Mismatches: []ChecksumMismatch{
+				{File: "demo/demo-1.0.0.tgz", Field: "shasum"},
+				{File: "demo/demo-1.0.0.tgz", Field: "integrity"},
+			}},
+		},
+		{
+			name: "缺少及多出 tarball",
+			modify: func(t *testing.T, dir string) {
+				pkgPath := filepath.Join(dir, PatchDirname, "demo")
+				if err := os.Rename(filepath.Join(pkgPath, "demo-1.0.0.tgz"), filepath.Join(pkgPath, "demo-1.1.0.tgz")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: &VerifyError{Missing: []string{"demo/demo-1.0.0.tgz"}, Extra: []string{"demo/demo-1.1.0.tgz"}, Mismatches: []ChecksumMismatch{}},
+		},
+		{
+			name: "没有清单的旧版补丁包",
+			modify: func(t *testing.T, dir string) {
+				if err := os.Remove(filepath.Join(dir, ManifestFile)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "不支持的清单版本",
+			modify: func(t *testing.T, dir string) {
+				updateManifest(t, dir, func(m *Manifest) { m.FormatVersion = ManifestFormatVersion + 1 })
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeBundle(t)
+			tt.modify(t, dir)
+
+			_, err := Verify(Resolve(dir), nil)
+			var verifyErr *VerifyError
+			switch {
+			case tt.wantErr:
+				if err == nil || errors.As(err, &verifyErr) {
+					t.Fatalf("Verify() error = %v, want 读取清单失败", err)
+				}
+				return
+			case tt.want == nil:
+				if err != nil {
+					t.Fatalf("Verify() error = %v", err)
+				}
+				return
+			case !errors.As(err, &verifyErr):
+				t.Fatalf("Verify() error = %v, want *VerifyError", err)
+			}
+			// 只比较文件和字段，实际的哈希值由文件内容决定
+			for i := range verifyErr.Mismatches {
+				verifyErr.Mismatches[i].Expected, verifyErr.Mismatches[i].Actual = "", ""
+			}
+			if !reflect.DeepEqual(verifyErr, tt.want) {
+				t.Errorf("Verify() = %+v, want %+v", verifyErr, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package bundle
+
+import (
+	"sort"
+	"sync"
+	"time"
+	"verda/pkg/upload"
+	"verda/pkg/verdaccio"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Preview 补丁包的预览，解压后的内容保留在工作目录中，确认后可以直接应用
+type Preview struct {
+	ID        string                 `json:"id"`
+	Source    string                 `json:"source"`
+	CreatedAt time.Time              `json:"createdAt"`
+	ExpiresAt time.Time              `json:"expiresAt"`
+	Report    *verdaccio.PatchReport `json:"report"`
+	// 补丁包清单，旧版补丁包为 nil
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// 补丁包序号与已应用的补丁包不连续时的提示
+	SequenceWarning string `json:"sequenceWarning,omitempty"`
+
+	ws       *upload.Workspace
+	patchDir string
+}
+
+var (
+	previewMu sync.Mutex
+	previews  = make(map[string]*Preview)
+)
+
+// NewPreview 按清单校验补丁目录并生成变更报告，预览在 ttl 后过期，过期或应用后工作目录会被删除
+func NewPreview(ws *upload.Workspace, patchDir, source string, ttl time.Duration, opts verdaccio.PatchOptions) (*Preview, error) {
+	manifest, err := Verify(patchDir, opts.Limiter)
+	if err != nil {
+		return nil, err
+	}
+	report, err := verdaccio.PreviewStorage(patchDir, opts)
+	if err != nil {
+		return nil, errors.WithMessage(err, "生成变更报告失败")
+	}
+
+	now := time.Now()
+	p := &Preview{
+		ID:        uuid.NewString(),
+		Source:    source,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Report:    report,
+		Manifest:  manifest,
+		ws:        ws,
+		patchDir:  patchDir,
+	}
+
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	expirePreviews()
+	previews[p.ID] = p
+	return p, nil
+}
+
+// GetPreview 获取预览
+func GetPreview(id string) (*Preview, error) {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	expirePreviews()
+	p, ok := previews[id]
+	if !ok {
+		return nil, errors.New("预览不存在或已过期：" + id)
+	}
+	return p, nil
+}
+
+// ListPreviews 获取所有未过期的预览，按创建时间倒序
+func ListPreviews() []*Preview {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	expirePreviews()
+	list := make([]*Preview, 0, len(previews))
+	for _, p := range previews {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// TakePreview 取出预览用于应用，取出后其他请求无法再获取该预览
+func TakePreview(id string) (*Preview, error) {
+	previewMu.Lock()
+	defer previewMu.Unlock()
+	expirePreviews()
+	p, ok := previews[id]
+	if !ok {
+		return nil, errors.New("预览不存在或已过期：" + id)
+	}
+	delete(previews, id)
+	return p, nil
+}
+
+// DiscardPreview 放弃预览并删除其工作目录
+func DiscardPreview(id string) error {
+	p, err := TakePreview(id)
+	if err != nil {
+		return err
+	}
+	return p.Close()
+}
+
+// PatchDir 预览对应的补丁目录
+func (p *Preview) PatchDir() string {
+	return p.patchDir
+}
+
+// Close 删除预览的工作目录
+func (p *Preview) Close() error {
+	if p.ws == nil {
+		return nil
+	}
+	return p.ws.Close()
+}
+
+func expirePreviews() {
+	now := time.Now()
+	for id, p := range previews {
+		if now.After(p.ExpiresAt) {
+			delete(previews, id)
+			_ = p.Close()
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"verda/pkg/history"
+	"verda/pkg/verdaccio"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Config verda 的配置文件（通过 -config 指定），所有配置项均可省略
+type Config struct {
+	Rewrite   Rewrite   `yaml:"rewrite"`
+	Normalize Normalize `yaml:"normalize"`
+	Policy    Policy    `yaml:"policy"`
+	Protect   Protect   `yaml:"protect"`
+	Rename    Rename    `yaml:"rename"`
+	Sequence  Sequence  `yaml:"sequence"`
+}
+
+// Rewrite registry 地址替换配置
+type Rewrite struct {
+	// 为 true 时 patch 不替换地址
+	Disabled bool `yaml:"disabled"`
+	// 内网 registry 地址，为空时使用显式指定的 -registry，两者均为空时不替换地址
+	Target string `yaml:"target"`
+	// 需要替换的公网 registry 地址，为空时使用 verdaccio.DefaultRewriteSources
+	Sources []string `yaml:"sources"`
+	// scope 包的替换规则，key 为 @scope
+	Scopes map[string]ScopeRewrite `yaml:"scopes"`
+}
+
+// Normalize 上游元数据清理配置
+type Normalize struct {
+	// 为 true 时 patch、整理 storage 不清理上游元数据
+	Disabled bool `yaml:"disabled"`
+	// 需要删除的顶层字段，为空时使用 verdaccio.DefaultStripFields
+	StripFields []string `yaml:"stripFields"`
+}
+
+// Policy 补丁包准入策略，所有规则均可省略
+type Policy struct {
+	// 允许的包名，支持 * 和 ? 通配符，为空时允许所有包
+	Allow []string `yaml:"allow"`
+	// 禁止的包名，优先于 allow
+	Deny []string `yaml:"deny"`
+	// 允许的 license，为空时不检查
+	Licenses []string `yaml:"licenses"`
+	// tarball 大小上限，支持 K/M/G/T 单位，为空或 0 时不限制
+	MaxTarballSize string `yaml:"maxTarballSize"`
+	// 禁止包含安装脚本的版本
+	DenyInstallScripts bool `yaml:"denyInstallScripts"`
+	// 禁止已废弃的版本
+	DenyDeprecated bool `yaml:"denyDeprecated"`
+}
+
+// Protect 受保护的包配置，内网发布的包（.verdaccio-db.json）始终受保护
+type Protect struct {
+	// 为 true 时不检查受保护的包
+	Disabled bool `yaml:"disabled"`
+	// 额外受保护的包名，支持 * 和 ? 通配符
+	Names []string `yaml:"names"`
+	// 补丁包试图修改受保护的包时的处理方式：block（默认）、quarantine
+	Action string `yaml:"action"`
+}
+
+// Rename 导入时重命名包的配置
+type Rename struct {
+	// 包名映射，如 foo: "@corp/foo"
+	Packages map[string]string `yaml:"packages"`
+	// scope 映射，如 "@vue": "@corp-vue"
+	Scopes map[string]string `yaml:"scopes"`
+	// 为 true 时将补丁包中对被重命名的包的依赖改为别名
+	Dependencies bool `yaml:"dependencies"`
+}
+
+// Sequence 补丁包序号检查配置，按补丁包清单中的 sequence 和 previousHash 检查同一来源的补丁包是否遗漏或重复
+type Sequence struct {
+	// 为 true 时不检查
+	Disabled bool `yaml:"disabled"`
+	// 序号不连续时的处理方式：warn（默认）、refuse
+	Action string `yaml:"action"`
+}
+
+type ScopeRewrite struct {
+	Sources []string `yaml:"sources"`
+	Target  string   `yaml:"target"`
+}
+
+var current = &Config{
+	Protect:  Protect{Action: string(verdaccio.ProtectBlock)},
+	Sequence: Sequence{Action: string(history.SequenceWarn)},
+}
+
+// Load 加载配置文件，path 为空时使用默认配置
+func Load(path string) error {
+	if path == "" {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "无法读取配置文件：%s", path)
+	}
+	c := &Config{}
+	if err = yaml.Unmarshal(content, c); err != nil {
+		return errors.Wrapf(err, "无法解析配置文件：%s", path)
+	}
+	for scope := range c.Rewrite.Scopes {
+		if !strings.HasPrefix(scope, "@") {
+			return errors.Errorf("配置文件 rewrite.scopes 中的 scope 必须以 @ 开头：%s", scope)
+		}
+	}
+	if err = verdaccio.ValidateStripFields(c.Normalize.StripFields); err != nil {
+		return errors.WithMessage(err, "配置文件 normalize.stripFields 错误")
+	}
+	if _, err = c.Policy.maxTarballSize(); err != nil {
+		return errors.WithMessage(err, "配置文件 policy.maxTarballSize 错误")
+	}
+	if c.Protect.Action == "" {
+		c.Protect.Action = string(verdaccio.ProtectBlock)
+	}
+	if _, err = verdaccio.ParseProtectAction(c.Protect.Action); err != nil {
+		return errors.WithMessage(err, "配置文件 protect.action 错误")
+	}
+	if err = verdaccio.ValidateRename(c.Rename.Packages, c.Rename.Scopes); err != nil {
+		return errors.WithMessage(err, "配置文件 rename 错误")
+	}
+	if c.Sequence.Action == "" {
+		c.Sequence.Action = string(history.SequenceWarn)
+	}
+	if _, err = history.ParseSequenceAction(c.Sequence.Action); err != nil {
+		return errors.WithMessage(err, "配置文件 sequence.action 错误")
+	}
+	current = c
+	return nil
+}
+
+// Get 获取当前配置
+func Get() *Config {
+	return current
+}
+
+// Rewriter 根据配置生成地址替换器，registry 为命令行中显式指定的 -registry（未指定时为空）。
+// rewrite.target 和 registry 均为空时没有替换目标，返回 nil
+func (c *Config) Rewriter(registry string) *verdaccio.Rewriter {
+	r := &verdaccio.Rewriter{
+		Target:  c.Rewrite.Target,
+		Sources: c.Rewrite.Sources,
+		Scopes:  make(map[string]verdaccio.ScopeRewrite, len(c.Rewrite.Scopes)),
+	}
+	if r.Target == "" {
+		r.Target = registry
+	}
+	if r.Target == "" {
+		return nil
+	}
+	if len(r.Sources) == 0 {
+		r.Sources = verdaccio.DefaultRewriteSources
+	}
+	for scope, rule := range c.Rewrite.Scopes {
+		r.Scopes[scope] = verdaccio.ScopeRewrite{Sources: rule.Sources, Target: rule.Target}
+	}
+	return r
+}
+
+// Normalizer 根据配置生成上游元数据清理器，禁用时返回 nil
+func (c *Config) Normalizer() *verdaccio.Normalizer {
+	if c.Normalize.Disabled {
+		return nil
+	}
+	n := &verdaccio.Normalizer{StripFields: c.Normalize.StripFields}
+	if len(n.StripFields) == 0 {
+		n.StripFields = verdaccio.DefaultStripFields
+	}
+	return n
+}
+
+func (p Policy) maxTarballSize() (int64, error) {
+	if p.MaxTarballSize == "" {
+		return 0, nil
+	}
+	return utils.ParseSize(p.MaxTarballSize)
+}
+
+// AdmissionPolicy 根据配置生成准入策略，没有配置任何规则时返回 nil
+func (c *Config) AdmissionPolicy() *verdaccio.Policy {
+	size, _ := c.Policy.maxTarballSize()
+	p := &verdaccio.Policy{
+		Allow:              c.Policy.Allow,
+		Deny:               c.Policy.Deny,
+		Licenses:           c.Policy.Licenses,
+		MaxTarballSize:     size,
+		DenyInstallScripts: c.Policy.DenyInstallScripts,
+		DenyDeprecated:     c.Policy.DenyDeprecated,
+	}
+	if len(p.Allow) == 0 && len(p.Deny) == 0 && len(p.Licenses) == 0 && p.MaxTarballSize == 0 &&
+		!p.DenyInstallScripts && !p.DenyDeprecated {
+		return nil
+	}
+	return p
+}
+
+// Protection 根据配置及 storage 中的 .verdaccio-db.json 生成受保护的包，禁用时返回 nil
+func (c *Config) Protection() (*verdaccio.Protection, error) {
+	if c.Protect.Disabled {
+		return nil, nil
+	}
+	return verdaccio.LoadProtection(c.Protect.Names, verdaccio.ProtectAction(c.Protect.Action))
+}
+
+// Renamer 根据配置生成导入时的重命名规则，没有配置任何映射时返回 nil
+func (c *Config) Renamer() *verdaccio.Renamer {
+	if len(c.Rename.Packages) == 0 && len(c.Rename.Scopes) == 0 {
+		return nil
+	}
+	return &verdaccio.Renamer{Packages: c.Rename.Packages, Scopes: c.Rename.Scopes, Dependencies: c.Rename.Dependencies}
+}
+
+// SequenceAction 补丁包序号不连续时的处理方式，禁用时返回空
+func (c *Config) SequenceAction() history.SequenceAction {
+	if c.Sequence.Disabled {
+		return ""
+	}
+	return history.SequenceAction(c.Sequence.Action)
+}
@@ -0,0 +1,257 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"verda/pkg/bundle"
+	"verda/pkg/verdaccio"
+	"verda/utils"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Run 一次 patch 运行的记录，ID 与任务、快照的 ID 相同
+type Run struct {
+	ID string `json:"id"`
+	// 补丁包的文件名或服务器路径
+	Source   string `json:"source"`
+	Operator string `json:"operator"`
+	// 补丁包清单中记录的来源信息，没有清单的旧版补丁包为 nil
+	Bundle     *Bundle    `json:"bundle,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// 合并失败的包数量，部分包失败时补丁包的其余包仍已合并
+	Failures     int64      `json:"failures,omitempty"`
+	RolledBackAt *time.Time `json:"rolledBackAt,omitempty"`
+	// 补丁包序号检查的警告（序号不连续但仍然应用）
+	Warning  string    `json:"warning,omitempty"`
+	Packages []Package `json:"packages,omitempty"`
+
+	mu sync.Mutex
+}
+
+// Bundle 补丁包的来源信息
+type Bundle struct {
+	SourceRegistry string    `json:"sourceRegistry"`
+	CreatedAt      time.Time `json:"createdAt"`
+	Creator        string    `json:"creator"`
+	Sequence       int64     `json:"sequence"`
+	Hash           string    `json:"hash,omitempty"`
+	PreviousHash   string    `json:"previousHash,omitempty"`
+}
+
+// Package 一次运行中单个包新增的版本及变化的 dist-tags
+type Package struct {
+	Name     string                    `json:"name"`
+	Versions []string                  `json:"versions"`
+	DistTags []verdaccio.DistTagChange `json:"distTags"`
+	At       time.Time                 `json:"at"`
+}
+
+// Arrival 某个版本由哪次运行带入 storage
+type Arrival struct {
+	Version      string     `json:"version"`
+	RunID        string     `json:"runId"`
+	Source       string     `json:"source"`
+	Operator     string     `json:"operator"`
+	Bundle       *Bundle    `json:"bundle,omitempty"`
+	At           time.Time  `json:"at"`
+	RolledBackAt *time.Time `json:"rolledBackAt,omitempty"`
+}
+
+var (
+	root string
+	// 保证同一时间只有一个写入者修改已结束的记录
+	mu sync.Mutex
+)
+
+// Init 设置历史记录的保存目录
+func Init(dir string) error {
+	root, _ = filepath.Abs(dir)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建历史记录目录：%s", root)
+	}
+	return nil
+}
+
+// Begin 开始记录一次 patch 运行，manifest 为补丁包清单，没有清单时传 nil
+func Begin(id, source, operator string, manifest *bundle.Manifest) (*Run, error) {
+	if root == "" {
+		return nil, errors.New("历史记录目录未初始化")
+	}
+	r := &Run{
+		ID:        id,
+		Source:    source,
+		Operator:  operator,
+		StartedAt: time.Now(),
+		Packages:  make([]Package, 0),
+	}
+	if manifest != nil {
+		r.Bundle = &Bundle{
+			SourceRegistry: manifest.SourceRegistry,
+			CreatedAt:      manifest.CreatedAt,
+			Creator:        manifest.Creator,
+			Sequence:       manifest.Sequence,
+			Hash:           manifest.Hash,
+			PreviousHash:   manifest.PreviousHash,
+		}
+	}
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record 记录成功合并的包，没有新增版本且 dist-tags 没有变化的包不记录
+func (r *Run) Record(change verdaccio.PackageChange) {
+	if len(change.NewVersions) == 0 && len(change.DistTags) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Packages = append(r.Packages, Package{
+		Name:     change.Name,
+		Versions: change.NewVersions,
+		DistTags: change.DistTags,
+		At:       time.Now(),
+	})
+}
+
+// RecordFailure 记录一个合并失败的包
+func (r *Run) RecordFailure() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Failures++
+}
+
+// Finish 结束记录并保存，err 为运行的错误
+func (r *Run) Finish(err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.FinishedAt = &now
+	if err != nil {
+		r.Error = err.Error()
+	}
+	sort.Slice(r.Packages, func(i, j int) bool {
+		return r.Packages[i].Name < r.Packages[j].Name
+	})
+	return r.save()
+}
+
+// Get 获取运行记录
+func Get(id string) (*Run, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("非法的记录 ID：" + id)
+	}
+	return load(filepath.Join(root, id+".json"))
+}
+
+// List 获取所有运行记录（不含包明细），按开始时间倒序
+func List() ([]*Run, error) {
+	list, err := list()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range list {
+		r.Packages = nil
+	}
+	return list, nil
+}
+
+// ForPackage 获取包 name 每个版本的来源，按时间倒序。被回滚的运行同样列出，并标记回滚时间
+func ForPackage(name string) ([]Arrival, error) {
+	list, err := list()
+	if err != nil {
+		return nil, err
+	}
+	arrivals := make([]Arrival, 0)
+	for _, r := range list {
+		for _, pkg := range r.Packages {
+			if pkg.Name != name {
+				continue
+			}
+			for _, v := range pkg.Versions {
+				arrivals = append(arrivals, Arrival{
+					Version:      v,
+					RunID:        r.ID,
+					Source:       r.Source,
+					Operator:     r.Operator,
+					Bundle:       r.Bundle,
+					At:           pkg.At,
+					RolledBackAt: r.RolledBackAt,
+				})
+			}
+		}
+	}
+	sort.SliceStable(arrivals, func(i, j int) bool {
+		return arrivals[i].At.After(arrivals[j].At)
+	})
+	return arrivals, nil
+}
+
+// MarkRolledBack 标记运行已被回滚，没有对应记录时忽略
+func MarkRolledBack(id string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	r, err := Get(id)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return err
+	}
+	now := time.Now()
+	r.RolledBackAt = &now
+	return r.save()
+}
+
+func list() ([]*Run, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取历史记录目录失败：%s", root)
+	}
+	list := make([]*Run, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if r, err := load(filepath.Join(root, entry.Name())); err == nil {
+			list = append(list, r)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.After(list[j].StartedAt)
+	})
+	return list, nil
+}
+
+func load(path string) (*Run, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "记录不存在：%s", strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	r := &Run{}
+	if err = json.Unmarshal(content, r); err != nil {
+		return nil, errors.Wrapf(err, "无法解析记录：%s", filepath.Base(path))
+	}
+	return r, nil
+}
+
+func (r *Run) save() error {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "序列化历史记录失败")
+	}
+	if err = utils.WriteFileAtomic(filepath.Join(root, r.ID+".json"), content, 0644); err != nil {
+		return errors.Wrapf(err, "保存历史记录失败：%s", r.ID)
+	}
+	return nil
+}
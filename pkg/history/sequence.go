@@ -0,0 +1,126 @@
+package history
+
+import (
+	"fmt"
+	"time"
+	"verda/pkg/bundle"
+
+	"github.com/pkg/errors"
+)
+
+// SequenceAction 补丁包序号不连续时的处理方式
+type SequenceAction string
+
+const (
+	// SequenceWarn 记录警告后继续应用
+	SequenceWarn SequenceAction = "warn"
+	// SequenceRefuse 拒绝应用，可通过 force 强制应用
+	SequenceRefuse SequenceAction = "refuse"
+)
+
+// ParseSequenceAction 解析补丁包序号不连续时的处理方式
+func ParseSequenceAction(s string) (SequenceAction, error) {
+	switch a := SequenceAction(s); a {
+	case SequenceWarn, SequenceRefuse:
+		return a, nil
+	}
+	return "", errors.Errorf("未知的补丁包序号处理方式 %q，可选值：warn、refuse", s)
+}
+
+// SequenceError 补丁包序号与同一来源已应用的补丁包不连续
+type SequenceError struct {
+	Source   string `json:"source"`
+	Sequence int64  `json:"sequence"`
+	// 同一来源已应用的最大序号
+	Last int64 `json:"last"`
+	// gap-中间有补丁包未应用，replay-已应用过相同序号的补丁包，outOfOrder-早于已应用的补丁包，
+	// chain-记录的上一个补丁包哈希与已应用的补丁包不一致
+	Reason string `json:"reason"`
+	// replay 时为应用该序号的运行记录及其中合并失败的包数量
+	RunID     string     `json:"runId,omitempty"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+	Failures  int64      `json:"failures,omitempty"`
+}
+
+func (e *SequenceError) Error() string {
+	switch e.Reason {
+	case "gap":
+		missing := fmt.Sprintf("#%d", e.Last+1)
+		if e.Sequence-e.Last > 2 {
+			missing += fmt.Sprintf(" ~ #%d", e.Sequence-1)
+		}
+		return fmt.Sprintf("%s 的补丁包 #%d 与已应用的 #%d 不连续，缺少 %s", e.Source, e.Sequence, e.Last, missing)
+	case "replay":
+		msg := fmt.Sprintf("%s 的补丁包 #%d 已于 %s 应用（%s）", e.Source, e.Sequence, e.AppliedAt.Format(time.DateTime), e.RunID)
+		if e.Failures > 0 {
+			msg += fmt.Sprintf("，其中 %d 个包合并失败", e.Failures)
+		}
+		return msg
+	case "outOfOrder":
+		return fmt.Sprintf("%s 的补丁包 #%d 早于已应用的 #%d", e.Source, e.Sequence, e.Last)
+	default:
+		return fmt.Sprintf("%s 的补丁包 #%d 记录的上一个补丁包与已应用的 #%d 不一致", e.Source, e.Sequence, e.Last)
+	}
+}
+
+// CheckSequence 按同一来源（sourceRegistry）已应用且未回滚的补丁包检查 manifest 的序号，
+// 开始合并的运行即视为已应用，包括部分包失败、被取消或异常中断的运行。
+// 序号应为已应用的最大序号加 1，且记录的上一个补丁包哈希与其一致。
+// 没有清单、序号为 0 或该来源没有已应用的补丁包时不检查
+func CheckSequence(manifest *bundle.Manifest) (*SequenceError, error) {
+	if manifest == nil || manifest.Sequence <= 0 {
+		return nil, nil
+	}
+	list, err := list()
+	if err != nil {
+		return nil, err
+	}
+	var last *Run
+	for _, r := range list {
+		if r.Bundle == nil || r.Bundle.SourceRegistry != manifest.SourceRegistry || r.Bundle.Sequence <= 0 || r.RolledBackAt != nil {
+			continue
+		}
+		if r.Bundle.Sequence == manifest.Sequence {
+			appliedAt := r.StartedAt
+			if r.FinishedAt != nil {
+				appliedAt = *r.FinishedAt
+			}
+			return &SequenceError{Source: manifest.SourceRegistry, Sequence: manifest.Sequence, Last: r.Bundle.Sequence,
+				Reason: "replay", RunID: r.ID, AppliedAt: &appliedAt, Failures: r.Failures}, nil
+		}
+		if last == nil || r.Bundle.Sequence > last.Bundle.Sequence {
+			last = r
+		}
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	e := &SequenceError{Source: manifest.SourceRegistry, Sequence: manifest.Sequence, Last: last.Bundle.Sequence}
+	switch {
+	case manifest.Sequence < last.Bundle.Sequence:
+		e.Reason = "outOfOrder"
+	case manifest.Sequence > last.Bundle.Sequence+1:
+		e.Reason = "gap"
+	case manifest.PreviousHash != "" && last.Bundle.Hash != "" && manifest.PreviousHash != last.Bundle.Hash:
+		e.Reason = "chain"
+	default:
+		return nil, nil
+	}
+	return e, nil
+}
+
+// EnforceSequence 检查补丁包序号，不连续时 refuse 为 true 则返回错误，否则返回警告信息
+func EnforceSequence(manifest *bundle.Manifest, refuse bool) (string, error) {
+	e, err := CheckSequence(manifest)
+	if err != nil {
+		return "", errors.WithMessage(err, "检查补丁包序号失败")
+	}
+	if e == nil {
+		return "", nil
+	}
+	if refuse {
+		return "", errors.WithMessage(e, "已拒绝应用，确认需要乱序或重复应用时使用 force 重新提交")
+	}
+	return e.Error(), nil
+}
@@ -0,0 +1,190 @@
+package history
+
+import (
+	"testing"
+	"verda/pkg/bundle"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const testSource = "https://registry.npmjs.org/"
+
+// appliedRun 已应用的补丁包运行
+type appliedRun struct {
+	sequence int64
+	hash     string
+	// 合并失败的包数量
+	failures int
+	// 运行返回的错误
+	err        error
+	rolledBack bool
+	// 只开始合并，没有结束（如进程异常退出）
+	unfinished bool
+	source     string
+}
+
+func TestCheckSequence(t *testing.T) {
+	tests := []struct {
+		name     string
+		runs     []appliedRun
+		manifest *bundle.Manifest
+		// 期望的 reason，为空表示检查通过
+		want         string
+		wantLast     int64
+		wantFailures int64
+	}{
+		{
+			name:     "没有清单",
+			runs:     []appliedRun{{sequence: 1}},
+			manifest: nil,
+		},
+		{
+			name:     "序号为 0 不检查",
+			runs:     []appliedRun{{sequence: 3}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource},
+		},
+		{
+			name:     "第一个补丁包",
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 5},
+		},
+		{
+			name:     "连续",
+			runs:     []appliedRun{{sequence: 1, hash: "h1"}, {sequence: 2, hash: "h2"}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 3, PreviousHash: "h2"},
+		},
+		{
+			name:     "缺号",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 2}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 5},
+			want:     "gap", wantLast: 2,
+		},
+		{
+			name:     "重复应用",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 2}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 2},
+			want:     "replay", wantLast: 2,
+		},
+		{
+			name:     "乱序",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 3}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 2},
+			want:     "outOfOrder", wantLast: 3,
+		},
+		{
+			name:     "上一个补丁包哈希不一致",
+			runs:     []appliedRun{{sequence: 1, hash: "h1"}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 2, PreviousHash: "other"},
+			want:     "chain", wantLast: 1,
+		},
+		{
+			name:     "其他来源的补丁包不影响",
+			runs:     []appliedRun{{sequence: 7, source: "https://registry.npmmirror.com/"}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 1},
+		},
+		{
+			name:     "回滚的补丁包视为未应用",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 2, rolledBack: true}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 2},
+		},
+		{
+			name:     "部分包失败的运行视为已应用",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 2, failures: 3}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 2},
+			want:     "replay", wantLast: 2, wantFailures: 3,
+		},
+		{
+			name:     "部分包失败的运行之后缺号",
+			runs:     []appliedRun{{sequence: 1}, {sequence: 2, failures: 1, err: errors.New("已取消")}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 4},
+			want:     "gap", wantLast: 2,
+		},
+		{
+			name:     "中断的运行视为已应用",
+			runs:     []appliedRun{{sequence: 1, unfinished: true}},
+			manifest: &bundle.Manifest{SourceRegistry: testSource, Sequence: 1},
+			want:     "replay", wantLast: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Init(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tt.runs {
+				applyRun(t, r)
+			}
+
+			e, err := CheckSequence(tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if e != nil {
+					t.Fatalf("CheckSequence() = %v, want nil", e)
+				}
+				return
+			}
+			if e == nil {
+				t.Fatalf("CheckSequence() = nil, want %s", tt.want)
+			}
+			if e.Reason != tt.want || e.Last != tt.wantLast || e.Failures != tt.wantFailures {
+				t.Errorf("CheckSequence() = %+v, want reason %s, last %d, failures %d", e, tt.want, tt.wantLast, tt.wantFailures)
+			}
+			if e.Reason == "replay" && (e.RunID == "" || e.AppliedAt == nil) {
+				t.Errorf("replay 缺少运行记录：%+v", e)
+			}
+			if e.Error() == "" {
+				t.Error("Error() 为空")
+			}
+		})
+	}
+}
+
+// applyRun 按 r 记录一次补丁包运行
+func applyRun(t *testing.T, r appliedRun) {
+	t.Helper()
+	source := r.source
+	if source == "" {
+		source = testSource
+	}
+	id := uuid.NewString()
+	run, err := Begin(id, "bundle.zip", "tester", &bundle.Manifest{SourceRegistry: source, Sequence: r.sequence, Hash: r.hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.unfinished {
+		return
+	}
+	for range r.failures {
+		run.RecordFailure()
+	}
+	if err = run.Finish(r.err); err != nil {
+		t.Fatal(err)
+	}
+	if r.rolledBack {
+		if err = MarkRolledBack(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEnforceSequence(t *testing.T) {
+	if err := Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	applyRun(t, appliedRun{sequence: 1})
+	manifest := &bundle.Manifest{SourceRegistry: testSource, Sequence: 3}
+
+	warning, err := EnforceSequence(manifest, false)
+	if err != nil || warning == "" {
+		t.Errorf("warn: EnforceSequence() = %q, %v", warning, err)
+	}
+	if _, err = EnforceSequence(manifest, true); err == nil {
+		t.Error("refuse: EnforceSequence() 应返回错误")
+	}
+	manifest.Sequence = 2
+	if warning, err = EnforceSequence(manifest, true); err != nil || warning != "" {
+		t.Errorf("连续时 EnforceSequence() = %q, %v", warning, err)
+	}
+}
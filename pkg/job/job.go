@@ -0,0 +1,439 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Succeeded State = "succeeded"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+// Finished 任务是否已结束
+func (s State) Finished() bool {
+	return s == Succeeded || s == Failed || s == Cancelled
+}
+
+// Result 任务中单个包的处理结果
+type Result struct {
+	Pkg    string `json:"pkg"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+	// 有意跳过等不算失败、但需要提示的情况
+	Warning string `json:"warning,omitempty"`
+	Detail  any    `json:"detail,omitempty"`
+}
+
+// Status 任务状态，会持久化到磁盘
+type Status struct {
+	ID       string   `json:"id"`
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	State    State    `json:"state"`
+	Error    string   `json:"error,omitempty"`
+	Total    int64    `json:"total"`
+	Progress int64    `json:"progress"`
+	Failures int64    `json:"failures"`
+	Results  []Result `json:"results,omitempty"`
+	// 任务的附加信息，如补丁包清单
+	Detail     any        `json:"detail,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Event 任务进度事件
+type Event struct {
+	Result   Result `json:"result"`
+	Progress int64  `json:"progress"`
+	Total    int64  `json:"total"`
+}
+
+// RunFunc 任务的执行函数，ctx 在任务被取消时结束
+type RunFunc func(ctx context.Context, j *Job) error
+
+type Job struct {
+	mu          sync.Mutex
+	saveMu      sync.Mutex
+	status      Status
+	run         RunFunc
+	ctx         context.Context
+	cancel      context.CancelFunc
+	subscribers map[chan Event]bool
+	cleanups    []func()
+	savedAt     time.Time
+}
+
+// 进度持久化的最小间隔
+const saveInterval = time.Second
+
+// LockFile storage 锁文件名，位于数据目录中。任务执行期间以及命令行导入补丁包时持有，
+// 避免服务和命令行同时修改 storage
+const LockFile = "storage.lock"
+
+var (
+	dir      string
+	lockPath string
+	mu       sync.Mutex
+	jobs     = make(map[string]*Job)
+	queue    = make(chan *Job, 1024)
+)
+
+// Init 加载已持久化的任务并启动任务队列，服务重启前未结束的任务会被标记为失败。
+// lockFile 为 storage 锁文件，每个任务执行前获取
+func Init(jobDir, lockFile string) error {
+	dir, _ = filepath.Abs(jobDir)
+	lockPath = lockFile
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建任务目录：%s", dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "读取任务目录失败：%s", dir)
+	}
+	for _, entry := range entries {
+		status, err := load(filepath.Join(dir, entry.Name()))
+		if err != nil || status.State.Finished() {
+			continue
+		}
+		now := time.Now()
+		status.State = Failed
+		status.Error = "服务重启，任务中断"
+		status.FinishedAt = &now
+		j := &Job{status: *status}
+		if err = j.save(); err != nil {
+			log.Errorf("保存任务失败 %s: %v", status.ID, err)
+		}
+	}
+
+	go worker()
+	return nil
+}
+
+// worker 按提交顺序逐个执行任务，避免多个任务同时修改 storage
+func worker() {
+	for j := range queue {
+		j.execute()
+	}
+}
+
+// Submit 提交任务，任务按提交顺序在后台执行；cleanups 在任务结束（包括排队时被取消）后执行
+func Submit(typ, title string, run RunFunc, cleanups ...func()) (*Job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		status: Status{
+			ID:        uuid.NewString(),
+			Type:      typ,
+			Title:     title,
+			State:     Queued,
+			Results:   make([]Result, 0),
+			CreatedAt: time.Now(),
+		},
+		run:         run,
+		cleanups:    cleanups,
+		ctx:         ctx,
+		cancel:      cancel,
+		subscribers: make(map[chan Event]bool),
+	}
+	if err := j.save(); err != nil {
+		cancel()
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+		return nil, err
+	}
+
+	mu.Lock()
+	jobs[j.status.ID] = j
+	mu.Unlock()
+
+	select {
+	case queue <- j:
+	default:
+		j.finish(errors.New("任务队列已满"))
+		return nil, errors.New("任务队列已满，请稍后重试")
+	}
+	return j, nil
+}
+
+// Get 获取任务，包括服务重启前的历史任务
+func Get(id string) (*Job, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("非法的任务 ID：" + id)
+	}
+	mu.Lock()
+	j, ok := jobs[id]
+	mu.Unlock()
+	if ok {
+		return j, nil
+	}
+	status, err := load(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, errors.New("任务不存在：" + id)
+	}
+	return &Job{status: *status}, nil
+}
+
+// List 获取所有任务（不含单个包的结果），按创建时间倒序
+func List() ([]Status, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取任务目录失败：%s", dir)
+	}
+	list := make([]Status, 0, len(entries))
+	for _, entry := range entries {
+		id := entry.Name()[:len(entry.Name())-len(filepath.Ext(entry.Name()))]
+		j, err := Get(id)
+		if err != nil {
+			continue
+		}
+		status := j.Status()
+		status.Results = nil
+		list = append(list, status)
+	}
+	sort.Slice(list, func(i, k int) bool {
+		return list[i].CreatedAt.After(list[k].CreatedAt)
+	})
+	return list, nil
+}
+
+// Cancel 取消排队中或执行中的任务
+func Cancel(id string) error {
+	j, err := Get(id)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	state, cancel := j.status.State, j.cancel
+	j.mu.Unlock()
+	if state.Finished() || cancel == nil {
+		return errors.New("任务已结束：" + id)
+	}
+	cancel()
+	if state == Queued {
+		j.finish(context.Canceled)
+	}
+	return nil
+}
+
+func (j *Job) execute() {
+	j.mu.Lock()
+	if j.status.State != Queued {
+		j.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.status.State = Running
+	j.status.StartedAt = &now
+	j.mu.Unlock()
+	_ = j.save()
+
+	err := func() (err error) {
+		unlock, err := lockStorage(j.ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("任务异常：%v", r)
+			}
+		}()
+		return j.run(j.ctx, j)
+	}()
+	j.finish(err)
+}
+
+// lockStorage 获取 storage 锁，命令行导入等其他进程持有锁时每秒重试一次，直到获取成功或任务被取消
+func lockStorage(ctx context.Context) (func(), error) {
+	if lockPath == "" {
+		return func() {}, nil
+	}
+	var waiting bool
+	for {
+		unlock, err := utils.TryLock(lockPath)
+		if !errors.Is(err, utils.ErrLocked) {
+			return unlock, err
+		}
+		if !waiting {
+			log.Infof("storage 已被其他进程锁定（%s），等待释放", lockPath)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	if j.status.State.Finished() {
+		j.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.status.FinishedAt = &now
+	switch {
+	case j.ctx != nil && j.ctx.Err() != nil:
+		j.status.State = Cancelled
+		j.status.Error = "任务已取消"
+	case err != nil:
+		j.status.State = Failed
+		j.status.Error = err.Error()
+	case j.status.Failures > 0:
+		j.status.State = Failed
+		j.status.Error = "部分包处理失败"
+	default:
+		j.status.State = Succeeded
+	}
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = nil
+	cleanups := j.cleanups
+	j.cleanups = nil
+	j.mu.Unlock()
+
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+
+	if j.cancel != nil {
+		j.cancel()
+	}
+	if err := j.save(); err != nil {
+		log.Errorf("保存任务失败 %s: %v", j.status.ID, err)
+	}
+
+	mu.Lock()
+	delete(jobs, j.status.ID)
+	mu.Unlock()
+}
+
+// ID 任务 ID
+func (j *Job) ID() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status.ID
+}
+
+// Status 获取任务状态的副本
+func (j *Job) Status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.status
+	status.Results = append([]Result(nil), j.status.Results...)
+	return status
+}
+
+// SetDetail 设置任务的附加信息
+func (j *Job) SetDetail(detail any) {
+	j.mu.Lock()
+	j.status.Detail = detail
+	j.mu.Unlock()
+	_ = j.save()
+}
+
+// SetTotal 设置需要处理的包总数
+func (j *Job) SetTotal(total int64) {
+	j.mu.Lock()
+	j.status.Total = total
+	j.mu.Unlock()
+	_ = j.save()
+}
+
+// Report 记录单个包的处理结果并通知订阅者
+func (j *Job) Report(result Result) {
+	j.mu.Lock()
+	j.status.Progress++
+	if result.Error != "" {
+		j.status.Failures++
+	}
+	j.status.Results = append(j.status.Results, result)
+	event := Event{Result: result, Progress: j.status.Progress, Total: j.status.Total}
+	for ch := range j.subscribers {
+		// 订阅者消费过慢时丢弃事件，不阻塞任务
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	save := time.Since(j.savedAt) >= saveInterval
+	j.mu.Unlock()
+
+	if save {
+		_ = j.save()
+	}
+}
+
+// Subscribe 订阅任务进度，返回已有的结果以及后续事件的 channel，任务结束时 channel 会被关闭
+func (j *Job) Subscribe() ([]Event, <-chan Event, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	history := make([]Event, 0, len(j.status.Results))
+	for i, result := range j.status.Results {
+		history = append(history, Event{Result: result, Progress: int64(i + 1), Total: j.status.Total})
+	}
+
+	ch := make(chan Event, 1024)
+	if j.status.State.Finished() || j.subscribers == nil {
+		close(ch)
+		return history, ch, func() {}
+	}
+	j.subscribers[ch] = true
+	return history, ch, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if j.subscribers[ch] {
+			delete(j.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (j *Job) save() error {
+	// 串行写入，保证后写入的一定是更新的状态
+	j.saveMu.Lock()
+	defer j.saveMu.Unlock()
+
+	j.mu.Lock()
+	j.savedAt = time.Now()
+	content, err := json.Marshal(j.status)
+	id := j.status.ID
+	j.mu.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "序列化任务失败")
+	}
+	return utils.WriteFileAtomic(filepath.Join(dir, id+".json"), content, 0644)
+}
+
+func load(path string) (*Status, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	if err = json.Unmarshal(content, status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
@@ -0,0 +1,369 @@
+package snapshot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"verda/pkg/upstream"
+	"verda/utils"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	// 运行过程中每记录一个包向日志追加一行，运行结束后合并到 snapshot.json，避免每个包都重写整个快照
+	journalFile = "journal.jsonl"
+	packagesDir = "packages"
+)
+
+// Snapshot 一次 patch 运行修改 storage 之前的状态，用于将 storage 回滚到该次运行之前
+type Snapshot struct {
+	ID           string     `json:"id"`
+	Source       string     `json:"source"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	RolledBackAt *time.Time `json:"rolledBackAt,omitempty"`
+	Packages     []Entry    `json:"packages,omitempty"`
+
+	mu      sync.Mutex
+	journal *os.File
+}
+
+// Entry 单个包修改前的状态
+type Entry struct {
+	Name string `json:"name"`
+	// 包在 storage 中的目录
+	Path string `json:"path"`
+	// 修改前是否存在 package.json，存在时原文件保存在快照的 packages/<包名>/package.json
+	Existed bool `json:"existed"`
+	// 本次运行新增的文件
+	AddedFiles []string `json:"addedFiles"`
+	// 包的上游版本记录文件，为空表示未记录；修改前存在时原文件保存在快照的 packages/<包名>/upstream.json
+	UpstreamPath    string `json:"upstreamPath,omitempty"`
+	UpstreamExisted bool   `json:"upstreamExisted,omitempty"`
+}
+
+var (
+	root string
+	// 保证同一时间只有一个回滚在执行
+	rollbackMu sync.Mutex
+)
+
+// Init 设置快照的保存目录
+func Init(dir string) error {
+	root, _ = filepath.Abs(dir)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建快照目录：%s", root)
+	}
+	return nil
+}
+
+// New 为一次 patch 运行创建快照，id 通常为任务 ID
+func New(id, source string) (*Snapshot, error) {
+	if root == "" {
+		return nil, errors.New("快照目录未初始化")
+	}
+	s := &Snapshot{
+		ID:        id,
+		Source:    source,
+		CreatedAt: time.Now(),
+		Packages:  make([]Entry, 0),
+	}
+	if err := os.MkdirAll(s.dir(), os.ModePerm); err != nil {
+		return nil, errors.Wrapf(err, "无法创建快照目录：%s", s.dir())
+	}
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 获取快照
+func Get(id string) (*Snapshot, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("非法的快照 ID：" + id)
+	}
+	return load(filepath.Join(root, id))
+}
+
+// List 获取所有快照（不含包明细），按创建时间倒序
+func List() ([]*Snapshot, error) {
+	list, err := list()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range list {
+		s.Packages = nil
+	}
+	return list, nil
+}
+
+func list() ([]*Snapshot, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取快照目录失败：%s", root)
+	}
+	list := make([]*Snapshot, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if s, err := load(filepath.Join(root, entry.Name())); err == nil {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+func load(dir string) (*Snapshot, error) {
+	content, err := os.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "快照不存在：%s", filepath.Base(dir))
+	}
+	s := &Snapshot{}
+	if err = json.Unmarshal(content, s); err != nil {
+		return nil, errors.Wrapf(err, "无法解析快照：%s", filepath.Base(dir))
+	}
+	if err = s.readJournal(dir); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readJournal 合并尚未写入 snapshot.json 的记录（运行中或异常中断的运行），已在 snapshot.json 中的包忽略
+func (s *Snapshot) readJournal(dir string) error {
+	file, err := os.Open(filepath.Join(dir, journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "无法读取快照日志：%s", filepath.Base(dir))
+	}
+	defer file.Close()
+
+	recorded := make(map[string]bool, len(s.Packages))
+	for _, entry := range s.Packages {
+		recorded[entry.Name] = true
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		// 中断时最后一行可能不完整，跳过无法解析的行
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil || recorded[entry.Name] {
+			continue
+		}
+		recorded[entry.Name] = true
+		s.Packages = append(s.Packages, entry)
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.Wrapf(err, "无法读取快照日志：%s", filepath.Base(dir))
+	}
+	return nil
+}
+
+func (s *Snapshot) dir() string {
+	return filepath.Join(root, s.ID)
+}
+
+func (s *Snapshot) save() error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "序列化快照失败")
+	}
+	if err = utils.WriteFileAtomic(filepath.Join(s.dir(), snapshotFile), content, 0644); err != nil {
+		return errors.Wrapf(err, "保存快照失败：%s", s.ID)
+	}
+	// snapshot.json 已包含所有记录，删除日志
+	if err = os.Remove(filepath.Join(s.dir(), journalFile)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "删除快照日志失败：%s", s.ID)
+	}
+	return nil
+}
+
+// Record 在修改包之前调用，保存包目录 pkgPath 下原有的 package.json 及包的上游版本记录，并记录即将新增的文件
+func (s *Snapshot) Record(name, pkgPath string, added []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := Entry{Name: name, Path: pkgPath, AddedFiles: added, UpstreamPath: upstream.RecordPath(name)}
+	original := filepath.Join(pkgPath, "package.json")
+	if utils.PathExists(original) {
+		backup := filepath.Join(s.dir(), packagesDir, name, "package.json")
+		if err := utils.CopyFileAtomic(original, backup); err != nil {
+			return errors.Wrapf(err, "备份 package.json 失败：%s", name)
+		}
+		entry.Existed = true
+	}
+	if entry.UpstreamPath != "" && utils.PathExists(entry.UpstreamPath) {
+		backup := filepath.Join(s.dir(), packagesDir, name, "upstream.json")
+		if err := utils.CopyFileAtomic(entry.UpstreamPath, backup); err != nil {
+			return errors.Wrapf(err, "备份上游版本记录失败：%s", name)
+		}
+		entry.UpstreamExisted = true
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "序列化快照失败")
+	}
+	if s.journal == nil {
+		if s.journal, err = os.OpenFile(filepath.Join(s.dir(), journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return errors.Wrapf(err, "无法打开快照日志：%s", s.ID)
+		}
+	}
+	if _, err = s.journal.Write(append(line, '\n')); err != nil {
+		return errors.Wrapf(err, "保存快照失败：%s", s.ID)
+	}
+	s.Packages = append(s.Packages, entry)
+	return nil
+}
+
+// Finish 在运行结束后调用，将日志中的记录一次性写入 snapshot.json，s 为 nil 时忽略。
+// 未调用时（如进程中断）读取快照会合并日志中的记录
+func (s *Snapshot) Finish() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	if err := s.journal.Close(); err != nil {
+		return errors.Wrapf(err, "保存快照失败：%s", s.ID)
+	}
+	s.journal = nil
+	return s.save()
+}
+
+// Rollback 将快照中记录的包恢复为 patch 之前的状态：删除新增的文件，恢复或删除 package.json 及上游版本记录。
+// 版本同步到本地时从心愿单中移除的条目不会恢复。
+// 之后的运行修改过相同包且尚未回滚时拒绝回滚，避免覆盖之后的修改。每处理完一个包回调一次 progress
+func Rollback(ctx context.Context, id string, progress func(name string, err error)) error {
+	rollbackMu.Lock()
+	defer rollbackMu.Unlock()
+
+	s, err := Get(id)
+	if err != nil {
+		return err
+	}
+	if s.RolledBackAt != nil {
+		return errors.Errorf("快照已于 %s 回滚", s.RolledBackAt.Format(time.DateTime))
+	}
+	if err = s.checkLater(); err != nil {
+		return err
+	}
+
+	var failures int
+	// 倒序恢复，与应用顺序相反
+	for i := len(s.Packages) - 1; i >= 0; i-- {
+		if err = ctx.Err(); err != nil {
+			return errors.Wrap(err, "回滚已取消")
+		}
+		entry := s.Packages[i]
+		err := s.restore(entry)
+		if err != nil {
+			failures++
+		}
+		progress(entry.Name, err)
+	}
+	if failures > 0 {
+		return errors.Errorf("%d/%d 个包回滚失败", failures, len(s.Packages))
+	}
+
+	now := time.Now()
+	s.RolledBackAt = &now
+	return s.save()
+}
+
+// checkLater 检查快照之后是否有尚未回滚的运行修改过相同的包
+func (s *Snapshot) checkLater() error {
+	all, err := list()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool, len(s.Packages))
+	for _, entry := range s.Packages {
+		names[entry.Name] = true
+	}
+	later := make([]string, 0)
+	for _, other := range all {
+		if other.ID == s.ID || other.RolledBackAt != nil || !other.CreatedAt.After(s.CreatedAt) {
+			continue
+		}
+		for _, entry := range other.Packages {
+			if names[entry.Name] {
+				later = append(later, other.ID)
+				break
+			}
+		}
+	}
+	if len(later) > 0 {
+		return errors.Errorf("之后的补丁修改了相同的包，请先回滚：%s", strings.Join(later, "、"))
+	}
+	return nil
+}
+
+func (s *Snapshot) restore(entry Entry) error {
+	for _, file := range entry.AddedFiles {
+		if err := os.Remove(filepath.Join(entry.Path, file)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "删除 %s 失败", file)
+		}
+	}
+	if err := s.restoreUpstream(entry); err != nil {
+		return err
+	}
+
+	target := filepath.Join(entry.Path, "package.json")
+	if entry.Existed {
+		backup := filepath.Join(s.dir(), packagesDir, entry.Name, "package.json")
+		if err := utils.CopyFileAtomic(backup, target); err != nil {
+			return errors.Wrapf(err, "恢复 package.json 失败：%s", entry.Name)
+		}
+		return nil
+	}
+
+	// patch 之前不存在的包，删除 package.json，目录为空时一并删除
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "删除 package.json 失败：%s", entry.Name)
+	}
+	if entries, err := os.ReadDir(entry.Path); err == nil && len(entries) == 0 {
+		_ = os.Remove(entry.Path)
+		// scope 包还需要删除空的 scope 目录
+		if strings.HasPrefix(entry.Name, "@") {
+			if entries, err := os.ReadDir(filepath.Dir(entry.Path)); err == nil && len(entries) == 0 {
+				_ = os.Remove(filepath.Dir(entry.Path))
+			}
+		}
+	}
+	return nil
+}
+
+// restoreUpstream 恢复包的上游版本记录，修改前不存在时删除
+func (s *Snapshot) restoreUpstream(entry Entry) error {
+	if entry.UpstreamPath == "" {
+		return nil
+	}
+	if entry.UpstreamExisted {
+		backup := filepath.Join(s.dir(), packagesDir, entry.Name, "upstream.json")
+		if err := utils.CopyFileAtomic(backup, entry.UpstreamPath); err != nil {
+			return errors.Wrapf(err, "恢复上游版本记录失败：%s", entry.Name)
+		}
+		return nil
+	}
+	if err := os.Remove(entry.UpstreamPath); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "删除上游版本记录失败：%s", entry.Name)
+	}
+	return nil
+}
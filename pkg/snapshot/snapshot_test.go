@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"verda/pkg/upstream"
+	"verda/utils"
+
+	"github.com/google/uuid"
+)
+
+// writeFiles 在 dir 中写入文件（文件名 -> 内容）
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func initRoot(t *testing.T) string {
+	t.Helper()
+	if err := Init(filepath.Join(t.TempDir(), "snapshots")); err != nil {
+		t.Fatal(err)
+	}
+	return t.TempDir()
+}
+
+func TestRollback(t *testing.T) {
+	storage := initRoot(t)
+	demo := filepath.Join(storage, "demo")
+	ui := filepath.Join(storage, "@corp", "ui")
+	writeFiles(t, demo, map[string]string{"package.json": "old", "demo-1.0.0.tgz": "v1"})
+
+	s, err := New(uuid.NewString(), "patch.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Record("demo", demo, []string{"demo-1.1.0.tgz"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Record("@corp/ui", ui, []string{"ui-1.0.0.tgz"}); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟 patch 修改 storage
+	writeFiles(t, demo, map[string]string{"package.json": "new", "demo-1.1.0.tgz": "v2"})
+	writeFiles(t, ui, map[string]string{"package.json": "ui", "ui-1.0.0.tgz": "v1"})
+
+	var restored []string
+	err = Rollback(context.Background(), s.ID, func(name string, err error) {
+		if err != nil {
+			t.Errorf("回滚 %s 失败：%v", name, err)
+		}
+		restored = append(restored, name)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[0] != "@corp/ui" {
+		t.Errorf("restored = %v, want 倒序回滚", restored)
+	}
+	if content, _ := os.ReadFile(filepath.Join(demo, "package.json")); string(content) != "old" {
+		t.Errorf("package.json = %q, want old", content)
+	}
+	if utils.PathExists(filepath.Join(demo, "demo-1.1.0.tgz")) {
+		t.Error("新增的 tarball 应被删除")
+	}
+	if !utils.PathExists(filepath.Join(demo, "demo-1.0.0.tgz")) {
+		t.Error("原有的 tarball 不应被删除")
+	}
+	if utils.PathExists(filepath.Join(storage, "@corp")) {
+		t.Error("patch 之前不存在的包目录及空 scope 目录应被删除")
+	}
+
+	if got, err := Get(s.ID); err != nil || got.RolledBackAt == nil {
+		t.Errorf("Get() = %+v, %v, want 已回滚", got, err)
+	}
+	if err = Rollback(context.Background(), s.ID, func(string, error) {}); err == nil {
+		t.Error("重复回滚应返回错误")
+	}
+}
+
+func TestRollbackLaterSnapshot(t *testing.T) {
+	storage := initRoot(t)
+	demo := filepath.Join(storage, "demo")
+	writeFiles(t, demo, map[string]string{"package.json": "v0"})
+
+	first, err := New(uuid.NewString(), "first.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = first.Record("demo", demo, nil); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, demo, map[string]string{"package.json": "v1"})
+	second, err := New(uuid.NewString(), "second.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = second.Record("demo", demo, nil); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, demo, map[string]string{"package.json": "v2"})
+
+	noop := func(string, error) {}
+	if err = Rollback(context.Background(), first.ID, noop); err == nil {
+		t.Fatal("之后的快照修改了相同的包且未回滚时应拒绝回滚")
+	}
+	if err = Rollback(context.Background(), second.ID, noop); err != nil {
+		t.Fatal(err)
+	}
+	if err = Rollback(context.Background(), first.ID, noop); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(demo, "package.json")); string(content) != "v0" {
+		t.Errorf("package.json = %q, want v0", content)
+	}
+}
+
+func TestRollbackUpstream(t *testing.T) {
+	storage := initRoot(t)
+	if err := upstream.Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	seen := func(name string, versions ...string) *upstream.Package {
+		p := &upstream.Package{Name: name}
+		for _, v := range versions {
+			p.Versions = append(p.Versions, upstream.Version{Version: v})
+		}
+		return p
+	}
+	if err := upstream.Merge(seen("demo", "1.1.0"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(uuid.NewString(), "patch.tgz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"demo", "@corp/ui"} {
+		if err = s.Record(name, filepath.Join(storage, name), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 模拟 patch 同步了 demo@1.1.0 并记录了 @corp/ui 的上游版本
+	if err = upstream.Merge(seen("demo", "1.2.0"), []string{"1.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = upstream.Merge(seen("@corp/ui", "2.0.0"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Rollback(context.Background(), s.ID, func(name string, err error) {
+		if err != nil {
+			t.Errorf("回滚 %s 失败：%v", name, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if demo, _ := upstream.Get("demo"); len(demo.Versions) != 1 || demo.Versions[0].Version != "1.1.0" {
+		t.Errorf("demo upstream versions = %+v, want 恢复为 1.1.0", demo.Versions)
+	}
+	if utils.PathExists(upstream.RecordPath("@corp/ui")) {
+		t.Error("patch 之前不存在的上游版本记录应被删除")
+	}
+}
@@ -0,0 +1,206 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+// SessionUsage 上传会话的磁盘占用情况
+type SessionUsage struct {
+	*Session
+	UploadedChunks int       `json:"uploadedChunks"`
+	Bytes          int64     `json:"bytes"`
+	Busy           bool      `json:"busy"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	Expired        bool      `json:"expired"`
+}
+
+// Orphan 不属于任何有效上传会话的分片或临时目录
+type Orphan struct {
+	Path    string    `json:"path"`
+	Kind    string    `json:"kind"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"modTime"`
+	Expired bool      `json:"expired"`
+}
+
+type Usage struct {
+	Sessions   []SessionUsage `json:"sessions"`
+	Orphans    []Orphan       `json:"orphans"`
+	TotalBytes int64          `json:"totalBytes"`
+}
+
+// Inspect 统计所有上传会话以及孤立分片、临时目录的磁盘占用，超过 ttl 未更新的标记为过期
+func Inspect(ttl time.Duration) (*Usage, error) {
+	usage := &Usage{
+		Sessions: make([]SessionUsage, 0),
+		Orphans:  make([]Orphan, 0),
+	}
+	now := time.Now()
+
+	root := chunkRoot()
+	if utils.PathExists(root) {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取分片目录失败：%s", root)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(root, entry.Name())
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if !entry.IsDir() {
+				// 旧版上传接口保存的分片
+				usage.Orphans = append(usage.Orphans, Orphan{
+					Path:    path,
+					Kind:    "chunk",
+					Bytes:   info.Size(),
+					ModTime: info.ModTime(),
+					Expired: now.Sub(info.ModTime()) > ttl,
+				})
+				continue
+			}
+			s, err := load(path)
+			if err != nil {
+				usage.Orphans = append(usage.Orphans, Orphan{
+					Path:    path,
+					Kind:    "session",
+					Bytes:   utils.DirSize(path),
+					ModTime: info.ModTime(),
+					Expired: now.Sub(info.ModTime()) > ttl,
+				})
+				continue
+			}
+			uploaded, _ := s.Uploaded()
+			usage.Sessions = append(usage.Sessions, SessionUsage{
+				Session:        s,
+				UploadedChunks: len(uploaded),
+				Bytes:          utils.DirSize(path),
+				Busy:           IsBusy(s.ID),
+				ExpiresAt:      s.UpdatedAt.Add(ttl),
+				Expired:        now.Sub(s.UpdatedAt) > ttl,
+			})
+		}
+	}
+
+	wsRoot := workspaceRoot()
+	if utils.PathExists(wsRoot) {
+		entries, err := os.ReadDir(wsRoot)
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取临时工作目录失败：%s", wsRoot)
+		}
+		for _, entry := range entries {
+			if isActiveWorkspace(entry.Name()) {
+				continue
+			}
+			path := filepath.Join(wsRoot, entry.Name())
+			bytes, modTime := workspaceUsage(path)
+			// 不属于当前进程的工作目录可能是异常中断后残留的，也可能正被其他进程（如命令行导入）使用，
+			// 只有超过 ttl 未修改时才视为过期
+			usage.Orphans = append(usage.Orphans, Orphan{
+				Path:    path,
+				Kind:    "workspace",
+				Bytes:   bytes,
+				ModTime: modTime,
+				Expired: now.Sub(modTime) > ttl,
+			})
+		}
+	}
+
+	for _, s := range usage.Sessions {
+		usage.TotalBytes += s.Bytes
+	}
+	for _, o := range usage.Orphans {
+		usage.TotalBytes += o.Bytes
+	}
+	return usage, nil
+}
+
+// workspaceUsage 统计工作目录的大小及其中最近的修改时间
+func workspaceUsage(path string) (int64, time.Time) {
+	var (
+		size    int64
+		modTime time.Time
+	)
+	_ = filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		return nil
+	})
+	return size, modTime
+}
+
+// Collect 清理过期的上传会话和孤立文件，返回被清理的内容
+func Collect(ttl time.Duration) (*Usage, error) {
+	usage, err := Inspect(ttl)
+	if err != nil {
+		return nil, err
+	}
+	reclaimed := &Usage{
+		Sessions: make([]SessionUsage, 0),
+		Orphans:  make([]Orphan, 0),
+	}
+	for _, s := range usage.Sessions {
+		if !s.Expired {
+			continue
+		}
+		// Inspect 之后会话可能已开始处理，删除时重新检查
+		removed, err := removeIdle(s.ID)
+		if err != nil {
+			log.Errorf("清理过期上传会话失败 %s: %v", s.ID, err)
+			continue
+		}
+		if !removed {
+			continue
+		}
+		reclaimed.Sessions = append(reclaimed.Sessions, s)
+		reclaimed.TotalBytes += s.Bytes
+	}
+	for _, o := range usage.Orphans {
+		if !o.Expired {
+			continue
+		}
+		if err = os.RemoveAll(o.Path); err != nil {
+			log.Errorf("清理孤立文件失败 %s: %v", o.Path, err)
+			continue
+		}
+		reclaimed.Orphans = append(reclaimed.Orphans, o)
+		reclaimed.TotalBytes += o.Bytes
+	}
+	return reclaimed, nil
+}
+
+// StartJanitor 启动后台清理任务，每隔 interval 清理一次超过 ttl 未更新的上传
+func StartJanitor(ttl, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			reclaimed, err := Collect(ttl)
+			if err != nil {
+				log.Errorf("清理过期上传失败: %v", err)
+			} else if len(reclaimed.Sessions)+len(reclaimed.Orphans) > 0 {
+				log.Infof("清理过期上传 %d 个、孤立文件 %d 个，释放 %d 字节",
+					len(reclaimed.Sessions), len(reclaimed.Orphans), reclaimed.TotalBytes)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,37 @@
+package upload
+
+import (
+	"testing"
+	"verda/utils"
+)
+
+func TestCollectSkipsBusySessions(t *testing.T) {
+	t.Chdir(t.TempDir())
+	idle, err := Init("idle.zip", 10, 10, 1, "idle", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := Init("busy.zip", 10, 10, 1, "busy", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Acquire(busy.ID); err != nil {
+		t.Fatal(err)
+	}
+	defer Release(busy.ID)
+
+	// ttl 为 0 时所有会话都已过期
+	reclaimed, err := Collect(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed.Sessions) != 1 || reclaimed.Sessions[0].ID != idle.ID {
+		t.Errorf("reclaimed = %+v, want 只清理 %s", reclaimed.Sessions, idle.ID)
+	}
+	if utils.PathExists(idle.Dir()) {
+		t.Error("过期的上传会话应被删除")
+	}
+	if !utils.PathExists(busy.Dir()) {
+		t.Error("处理中的上传会话不应被删除")
+	}
+}
@@ -0,0 +1,93 @@
+package upload
+
+import (
+	"strings"
+	"verda/utils"
+
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/pkg/errors"
+)
+
+// Limits 上传限额及磁盘空间预检配置
+type Limits struct {
+	// 单个上传的最大字节数，0 表示不限制
+	MaxSize int64
+	// 所有进行中的上传声明大小之和的上限，0 表示不限制
+	MaxInflight int64
+	// 补丁包解压后相对压缩包的膨胀系数
+	ExpansionFactor float64
+	// verdaccio storage 路径
+	StoragePath string
+}
+
+// Requirement 某个路径所在磁盘需要的空间
+type Requirement struct {
+	Path  string
+	Bytes int64
+}
+
+// BundleRequirements 处理大小为 size 的补丁包所需的磁盘空间：
+// 分片目录保存分片，工作目录保存合并后的文件及解压内容，storage 保存解压后的包
+func BundleRequirements(size int64, limits Limits) []Requirement {
+	expanded := int64(float64(size) * limits.ExpansionFactor)
+	return []Requirement{
+		{Path: chunkRoot(), Bytes: size},
+		{Path: workspaceRoot(), Bytes: size + expanded},
+		{Path: limits.StoragePath, Bytes: expanded},
+	}
+}
+
+// CheckDiskSpace 检查各路径所在磁盘的可用空间是否满足需求，位于同一磁盘的需求会累加
+func CheckDiskSpace(requirements []Requirement) error {
+	type disk struct {
+		free  uint64
+		need  int64
+		paths []string
+	}
+	disks := make(map[uint64]*disk)
+	order := make([]uint64, 0)
+	for _, r := range requirements {
+		if r.Path == "" || r.Bytes <= 0 {
+			continue
+		}
+		free, device, err := utils.DiskUsage(r.Path)
+		if err != nil {
+			log.Warnf("无法获取 %s 所在磁盘的可用空间，跳过检查: %v", r.Path, err)
+			continue
+		}
+		d, ok := disks[device]
+		if !ok {
+			d = &disk{free: free}
+			disks[device] = d
+			order = append(order, device)
+		}
+		d.need += r.Bytes
+		d.paths = append(d.paths, r.Path)
+	}
+	for _, device := range order {
+		d := disks[device]
+		if uint64(d.need) > d.free {
+			return errors.Errorf("磁盘空间不足：%s 所在磁盘需要 %s，可用 %s",
+				strings.Join(d.paths, "、"), utils.FormatSize(d.need), utils.FormatSize(int64(d.free)))
+		}
+	}
+	return nil
+}
+
+// checkLimits 检查新上传是否超出限额，sessions 为当前所有进行中的上传会话
+func checkLimits(size int64, sessions []*Session, limits Limits) error {
+	if limits.MaxSize > 0 && size > limits.MaxSize {
+		return errors.Errorf("文件大小 %s 超过单个上传上限 %s", utils.FormatSize(size), utils.FormatSize(limits.MaxSize))
+	}
+	if limits.MaxInflight > 0 {
+		var inflight int64
+		for _, s := range sessions {
+			inflight += s.Size
+		}
+		if inflight+size > limits.MaxInflight {
+			return errors.Errorf("进行中的上传共 %s，再上传 %s 将超过上限 %s",
+				utils.FormatSize(inflight), utils.FormatSize(size), utils.FormatSize(limits.MaxInflight))
+		}
+	}
+	return CheckDiskSpace(BundleRequirements(size, limits))
+}
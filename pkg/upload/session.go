@@ -0,0 +1,283 @@
+package upload
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"verda/utils"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ChunkDir 分片根目录，每个上传会话对应其中的一个子目录
+const ChunkDir = "chunk"
+
+const sessionFile = "session.json"
+
+// Session 上传会话，会话信息与已上传的分片均保存在 ChunkDir/<id> 目录下，服务重启后仍可续传
+type Session struct {
+	ID         string    `json:"id"`
+	Filename   string    `json:"filename"`
+	Size       int64     `json:"size"`
+	ChunkSize  int64     `json:"chunkSize"`
+	ChunkCount int       `json:"chunkCount"`
+	MD5        string    `json:"md5"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+var mu sync.Mutex
+
+func chunkRoot() string {
+	dir, _ := filepath.Abs(ChunkDir)
+	return dir
+}
+
+// Init 创建上传会话；如果已存在相同文件（md5、大小、分片大小一致）的会话则直接返回该会话以便续传。
+// 创建新会话前会检查上传限额和磁盘空间，不满足时直接拒绝，不写入任何数据
+func Init(filename string, size, chunkSize int64, chunkCount int, md5 string, limits Limits) (*Session, error) {
+	if md5 == "" {
+		return nil, errors.New("md5 不能为空")
+	}
+	if size <= 0 || chunkSize <= 0 || chunkCount <= 0 {
+		return nil, errors.New("文件大小、分片大小和分片数量必须大于 0")
+	}
+	if int64(chunkCount) != (size+chunkSize-1)/chunkSize {
+		return nil, errors.Errorf("分片数量 %d 与文件大小 %d、分片大小 %d 不匹配", chunkCount, size, chunkSize)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	sessions, err := list()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sessions {
+		if s.MD5 == md5 && s.Size == size && s.ChunkSize == chunkSize {
+			return s, nil
+		}
+	}
+	if err = checkLimits(size, sessions, limits); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s := &Session{
+		ID:         uuid.NewString(),
+		Filename:   filepath.Base(filename),
+		Size:       size,
+		ChunkSize:  chunkSize,
+		ChunkCount: chunkCount,
+		MD5:        md5,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err = os.MkdirAll(s.Dir(), os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "无法创建分片目录")
+	}
+	if err = s.save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 根据会话 ID 获取上传会话
+func Get(id string) (*Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, errors.New("非法的会话 ID：" + id)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return load(filepath.Join(chunkRoot(), id))
+}
+
+// List 获取所有上传会话
+func List() ([]*Session, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	return list()
+}
+
+// Remove 删除上传会话及其所有分片
+func Remove(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errors.New("非法的会话 ID：" + id)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err := os.RemoveAll(filepath.Join(chunkRoot(), id)); err != nil {
+		return errors.Wrapf(err, "删除上传会话失败：%s", id)
+	}
+	return nil
+}
+
+func list() ([]*Session, error) {
+	root := chunkRoot()
+	if !utils.PathExists(root) {
+		return []*Session{}, nil
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取分片目录失败：%s", root)
+	}
+	sessions := make([]*Session, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if s, err := load(filepath.Join(root, entry.Name())); err == nil {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func load(dir string) (*Session, error) {
+	content, err := os.ReadFile(filepath.Join(dir, sessionFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "上传会话不存在：%s", filepath.Base(dir))
+	}
+	s := &Session{}
+	if err = json.Unmarshal(content, s); err != nil {
+		return nil, errors.Wrapf(err, "无法解析上传会话：%s", filepath.Base(dir))
+	}
+	return s, nil
+}
+
+func (s *Session) save() error {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "序列化上传会话失败")
+	}
+	if err = os.WriteFile(filepath.Join(s.Dir(), sessionFile), content, 0644); err != nil {
+		return errors.Wrapf(err, "保存上传会话失败：%s", s.ID)
+	}
+	return nil
+}
+
+// Dir 会话的分片目录
+func (s *Session) Dir() string {
+	return filepath.Join(chunkRoot(), s.ID)
+}
+
+func (s *Session) chunkPath(index int) string {
+	return filepath.Join(s.Dir(), strconv.Itoa(index))
+}
+
+// ExpectedChunkSize 第 index 个分片应有的大小，最后一个分片可能小于 ChunkSize
+func (s *Session) ExpectedChunkSize(index int) int64 {
+	if index == s.ChunkCount-1 {
+		return s.Size - s.ChunkSize*int64(s.ChunkCount-1)
+	}
+	return s.ChunkSize
+}
+
+// Uploaded 获取已保存的分片序号（升序）
+func (s *Session) Uploaded() ([]int, error) {
+	entries, err := os.ReadDir(s.Dir())
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取分片目录失败：%s", s.ID)
+	}
+	indexes := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		index, err := strconv.Atoi(entry.Name())
+		if err != nil || index < 0 || index >= s.ChunkCount {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// SaveChunk 保存第 index 个分片，先写入临时文件，大小校验通过后再重命名，避免中断时留下残缺分片
+func (s *Session) SaveChunk(index int, r io.Reader) error {
+	if index < 0 || index >= s.ChunkCount {
+		return errors.Errorf("分片序号 %d 超出范围 [0, %d)", index, s.ChunkCount)
+	}
+	chunkPath := s.chunkPath(index)
+	tmpPath := chunkPath + ".tmp-" + uuid.NewString()
+
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "分片 %d 保存失败", index)
+	}
+	written, err := io.Copy(tmp, r)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "分片 %d 保存失败", index)
+	}
+	if expected := s.ExpectedChunkSize(index); written != expected {
+		os.Remove(tmpPath)
+		return errors.Errorf("分片 %d 已损坏，大小 %d 与预期 %d 不一致", index, written, expected)
+	}
+	if err = os.Rename(tmpPath, chunkPath); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "分片 %d 保存失败", index)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	s.UpdatedAt = time.Now()
+	return s.save()
+}
+
+// Merge 按分片序号将所有分片合并到 output，并校验 md5
+func (s *Session) Merge(output string) error {
+	uploaded, err := s.Uploaded()
+	if err != nil {
+		return err
+	}
+	if len(uploaded) != s.ChunkCount {
+		return errors.Errorf("分片未上传完成：%d/%d", len(uploaded), s.ChunkCount)
+	}
+	chunks := make([]string, 0, s.ChunkCount)
+	for index := 0; index < s.ChunkCount; index++ {
+		chunks = append(chunks, s.chunkPath(index))
+	}
+	return MergeChunks(output, chunks, s.MD5)
+}
+
+// MergeChunks 按给定顺序合并分片文件，合并成功的分片会被删除，最后校验合并文件的 md5
+func MergeChunks(output string, chunks []string, md5 string) error {
+	outputFile, err := os.Create(output)
+	if err != nil {
+		return errors.Wrap(err, "创建合并文件失败")
+	}
+	defer outputFile.Close()
+
+	for _, chunkPath := range chunks {
+		chunkFile, err := os.Open(chunkPath)
+		if err != nil {
+			return errors.Wrapf(err, "分片 %s 读取失败", filepath.Base(chunkPath))
+		}
+		_, err = io.Copy(outputFile, chunkFile)
+		chunkFile.Close()
+		if err != nil {
+			return errors.Wrapf(err, "分片 %s 合并失败", filepath.Base(chunkPath))
+		}
+	}
+	if err = outputFile.Close(); err != nil {
+		return errors.Wrap(err, "写入合并文件失败")
+	}
+
+	if fileMD5 := utils.FileMD5(output); fileMD5 != md5 {
+		return errors.New("文件已损坏")
+	}
+
+	// 校验通过后再删除分片，校验失败时分片仍可用于重新合并
+	for _, chunkPath := range chunks {
+		if err = os.Remove(chunkPath); err != nil {
+			return errors.Wrapf(err, "删除分片 %s 失败", filepath.Base(chunkPath))
+		}
+	}
+	return nil
+}
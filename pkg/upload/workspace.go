@@ -0,0 +1,100 @@
+package upload
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// WorkspaceDir 临时工作目录根目录，合并后的补丁包及其解压内容都放在其中的子目录下
+const WorkspaceDir = "workspace"
+
+// Workspace 一次打补丁使用的临时工作目录，使用完毕后需要调用 Close 清理
+type Workspace struct {
+	ID  string
+	Dir string
+}
+
+var (
+	wsMu       sync.Mutex
+	workspaces = make(map[string]bool)
+	busy       = make(map[string]bool)
+)
+
+func workspaceRoot() string {
+	dir, _ := filepath.Abs(WorkspaceDir)
+	return dir
+}
+
+// NewWorkspace 创建临时工作目录，未被 Close 的工作目录在垃圾回收时不会被清理
+func NewWorkspace() (*Workspace, error) {
+	ws := &Workspace{ID: uuid.NewString()}
+	ws.Dir = filepath.Join(workspaceRoot(), ws.ID)
+
+	wsMu.Lock()
+	workspaces[ws.ID] = true
+	wsMu.Unlock()
+
+	if err := os.MkdirAll(ws.Dir, os.ModePerm); err != nil {
+		ws.Close()
+		return nil, errors.Wrap(err, "无法创建临时工作目录")
+	}
+	return ws, nil
+}
+
+// Close 删除临时工作目录，可以重复调用
+func (ws *Workspace) Close() error {
+	wsMu.Lock()
+	delete(workspaces, ws.ID)
+	wsMu.Unlock()
+
+	if err := os.RemoveAll(ws.Dir); err != nil {
+		return errors.Wrapf(err, "删除临时工作目录失败：%s", ws.Dir)
+	}
+	return nil
+}
+
+func isActiveWorkspace(id string) bool {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	return workspaces[id]
+}
+
+// Acquire 标记上传会话正在被合并，防止重复合并以及被垃圾回收
+func Acquire(id string) error {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	if busy[id] {
+		return errors.New("上传会话正在处理中：" + id)
+	}
+	busy[id] = true
+	return nil
+}
+
+// Release 取消上传会话的处理中标记
+func Release(id string) {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	delete(busy, id)
+}
+
+// IsBusy 上传会话是否正在处理中
+func IsBusy(id string) bool {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	return busy[id]
+}
+
+// removeIdle 删除未在处理中的上传会话，检查和删除期间持有处理中标记的锁，避免删除过程中会话被 Acquire；
+// 会话正在处理中时不删除，返回 false
+func removeIdle(id string) (bool, error) {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	if busy[id] {
+		return false, nil
+	}
+	return true, Remove(id)
+}
@@ -0,0 +1,209 @@
+package upstream
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"verda/utils"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// Version 上游存在但本地没有 tarball 的版本
+type Version struct {
+	Version    string `json:"version"`
+	Time       string `json:"time,omitempty"`
+	Tarball    string `json:"tarball,omitempty"`
+	Shasum     string `json:"shasum,omitempty"`
+	Integrity  string `json:"integrity,omitempty"`
+	Deprecated string `json:"deprecated,omitempty"`
+}
+
+// Package 单个包在上游存在但未同步到内网的版本，以及指向这些版本的 dist-tags
+type Package struct {
+	Name      string            `json:"name"`
+	Versions  []Version         `json:"versions"`
+	DistTags  map[string]string `json:"distTags"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+var (
+	root string
+	// 保证同一时间只有一个写入者修改记录及心愿单
+	mu sync.Mutex
+)
+
+const packagesDir = "packages"
+
+// Init 设置上游版本记录的保存目录
+func Init(dir string) error {
+	root, _ = filepath.Abs(dir)
+	if err := os.MkdirAll(filepath.Join(root, packagesDir), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建上游版本记录目录：%s", root)
+	}
+	return nil
+}
+
+func packagePath(name string) (string, error) {
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `\`) {
+		return "", errors.New("非法的包名：" + name)
+	}
+	return filepath.Join(root, packagesDir, filepath.FromSlash(name)+".json"), nil
+}
+
+// RecordPath 包 name 的上游版本记录文件，记录目录未初始化或包名非法时返回空字符串
+func RecordPath(name string) string {
+	if root == "" {
+		return ""
+	}
+	path, err := packagePath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Merge 合并整理、patch 时发现的上游版本 seen，并删除已同步到本地的版本 local（同时从心愿单中移除）。
+// 记录目录未初始化时不记录
+func Merge(seen *Package, local []string) error {
+	if root == "" || seen == nil {
+		return nil
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	p, err := get(seen.Name)
+	if err != nil {
+		return err
+	}
+	versions := lo.SliceToMap(p.Versions, func(v Version) (string, Version) { return v.Version, v })
+	for _, v := range seen.Versions {
+		versions[v.Version] = v
+	}
+	mirrored := make([]string, 0)
+	for _, v := range local {
+		if _, ok := versions[v]; ok {
+			mirrored = append(mirrored, v)
+			delete(versions, v)
+		}
+	}
+	tags := lo.Assign(p.DistTags, seen.DistTags)
+	for tag, v := range tags {
+		if _, ok := versions[v]; !ok {
+			delete(tags, tag)
+		}
+	}
+	if len(seen.Versions) > 0 || len(mirrored) > 0 {
+		p.UpdatedAt = time.Now()
+	}
+	p.Versions, p.DistTags = sortedVersions(versions), tags
+
+	if len(mirrored) > 0 {
+		if err = removeWishes(seen.Name, mirrored); err != nil {
+			return err
+		}
+	}
+	return save(p)
+}
+
+func sortedVersions(versions map[string]Version) []Version {
+	list := lo.Values(versions)
+	sort.SliceStable(list, func(i, j int) bool {
+		a, e1 := semver.NewVersion(list[i].Version)
+		b, e2 := semver.NewVersion(list[j].Version)
+		if e1 == nil && e2 == nil {
+			return a.LessThan(b)
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+func save(p *Package) error {
+	path, err := packagePath(p.Name)
+	if err != nil {
+		return err
+	}
+	if len(p.Versions) == 0 {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "删除上游版本记录失败：%s", p.Name)
+		}
+		return nil
+	}
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "序列化上游版本记录失败")
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建目录：%s", filepath.Dir(path))
+	}
+	return utils.WriteFileAtomic(path, content, 0644)
+}
+
+// Get 获取包 name 在上游存在但未同步的版本，没有记录时返回空的记录
+func Get(name string) (*Package, error) {
+	if root == "" {
+		return nil, errors.New("上游版本记录目录未初始化")
+	}
+	return get(name)
+}
+
+func get(name string) (*Package, error) {
+	p := &Package{Name: name, Versions: make([]Version, 0), DistTags: make(map[string]string)}
+	path, err := packagePath(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法读取上游版本记录：%s", name)
+	}
+	if err = json.Unmarshal(content, p); err != nil {
+		return nil, errors.Wrapf(err, "无法解析上游版本记录：%s", name)
+	}
+	if p.DistTags == nil {
+		p.DistTags = make(map[string]string)
+	}
+	return p, nil
+}
+
+// List 获取所有存在未同步版本的包，按包名排序
+func List() ([]*Package, error) {
+	if root == "" {
+		return nil, errors.New("上游版本记录目录未初始化")
+	}
+	dir := filepath.Join(root, packagesDir)
+	list := make([]*Package, 0)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		p, err := get(strings.TrimSuffix(filepath.ToSlash(rel), ".json"))
+		if err != nil {
+			return err
+		}
+		list = append(list, p)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取上游版本记录失败：%s", dir)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
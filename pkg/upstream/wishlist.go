@@ -0,0 +1,167 @@
+package upstream
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+const wishlistFile = "wishlist.json"
+
+// ManifestFile 导出的心愿单清单的文件名
+const ManifestFile = "verda-wishlist.json"
+
+// ManifestFormatVersion 当前导出的心愿单清单格式版本
+const ManifestFormatVersion = 1
+
+// Wish 心愿单中需要从外网获取的版本
+type Wish struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// 上游的下载地址及校验和，外网获取时使用
+	Tarball   string    `json:"tarball,omitempty"`
+	Integrity string    `json:"integrity,omitempty"`
+	Operator  string    `json:"operator"`
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// Manifest 导出给外网的心愿单清单，外网据此获取 tarball 并生成补丁包
+type Manifest struct {
+	FormatVersion int               `json:"formatVersion"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	Packages      []ManifestPackage `json:"packages"`
+}
+
+type ManifestPackage struct {
+	Name     string            `json:"name"`
+	Versions []ManifestVersion `json:"versions"`
+}
+
+type ManifestVersion struct {
+	Version   string `json:"version"`
+	Tarball   string `json:"tarball,omitempty"`
+	Integrity string `json:"integrity,omitempty"`
+}
+
+// Wishlist 获取心愿单，按包名、加入时间排序
+func Wishlist() ([]Wish, error) {
+	if root == "" {
+		return nil, errors.New("上游版本记录目录未初始化")
+	}
+	return loadWishlist()
+}
+
+func loadWishlist() ([]Wish, error) {
+	wishes := make([]Wish, 0)
+	path := filepath.Join(root, wishlistFile)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return wishes, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法读取心愿单：%s", path)
+	}
+	if err = json.Unmarshal(content, &wishes); err != nil {
+		return nil, errors.Wrapf(err, "无法解析心愿单：%s", path)
+	}
+	return wishes, nil
+}
+
+func saveWishlist(wishes []Wish) error {
+	sort.SliceStable(wishes, func(i, j int) bool {
+		if wishes[i].Name != wishes[j].Name {
+			return wishes[i].Name < wishes[j].Name
+		}
+		return wishes[i].AddedAt.Before(wishes[j].AddedAt)
+	})
+	content, err := json.MarshalIndent(wishes, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "序列化心愿单失败")
+	}
+	return utils.WriteFileAtomic(filepath.Join(root, wishlistFile), content, 0644)
+}
+
+// AddWishes 将包 name 在上游存在但未同步的版本 versions 加入心愿单，已在心愿单中的版本忽略。
+// 版本不在上游版本记录中时返回错误
+func AddWishes(name string, versions []string, operator string) ([]Wish, error) {
+	if root == "" {
+		return nil, errors.New("上游版本记录目录未初始化")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	p, err := get(name)
+	if err != nil {
+		return nil, err
+	}
+	known := lo.SliceToMap(p.Versions, func(v Version) (string, Version) { return v.Version, v })
+	if unknown := lo.Filter(versions, func(v string, _ int) bool { _, ok := known[v]; return !ok }); len(unknown) > 0 {
+		return nil, errors.Errorf("%s 的版本 %s 不在上游版本记录中", name, strings.Join(unknown, "、"))
+	}
+
+	wishes, err := loadWishlist()
+	if err != nil {
+		return nil, err
+	}
+	added := make([]Wish, 0, len(versions))
+	for _, version := range lo.Uniq(versions) {
+		if lo.ContainsBy(wishes, func(w Wish) bool { return w.Name == name && w.Version == version }) {
+			continue
+		}
+		v := known[version]
+		added = append(added, Wish{Name: name, Version: version, Tarball: v.Tarball, Integrity: v.Integrity, Operator: operator, AddedAt: time.Now()})
+	}
+	if err = saveWishlist(append(wishes, added...)); err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// RemoveWishes 从心愿单中移除包 name 的版本 versions，versions 为空时移除该包的所有版本
+func RemoveWishes(name string, versions []string) error {
+	if root == "" {
+		return errors.New("上游版本记录目录未初始化")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return removeWishes(name, versions)
+}
+
+func removeWishes(name string, versions []string) error {
+	wishes, err := loadWishlist()
+	if err != nil {
+		return err
+	}
+	kept := lo.Reject(wishes, func(w Wish, _ int) bool {
+		return w.Name == name && (len(versions) == 0 || lo.Contains(versions, w.Version))
+	})
+	if len(kept) == len(wishes) {
+		return nil
+	}
+	return saveWishlist(kept)
+}
+
+// Export 将心愿单导出为外网使用的清单
+func Export() (*Manifest, error) {
+	wishes, err := Wishlist()
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{FormatVersion: ManifestFormatVersion, CreatedAt: time.Now(), Packages: make([]ManifestPackage, 0)}
+	for _, w := range wishes {
+		v := ManifestVersion{Version: w.Version, Tarball: w.Tarball, Integrity: w.Integrity}
+		if n := len(m.Packages); n > 0 && m.Packages[n-1].Name == w.Name {
+			m.Packages[n-1].Versions = append(m.Packages[n-1].Versions, v)
+			continue
+		}
+		m.Packages = append(m.Packages, ManifestPackage{Name: w.Name, Versions: []ManifestVersion{v}})
+	}
+	return m, nil
+}
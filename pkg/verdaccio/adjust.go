@@ -0,0 +1,273 @@
+package verdaccio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"verda/pkg/snapshot"
+	"verda/pkg/upstream"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+type AjustMessage struct {
+	Pkg          string
+	AdjustResult string
+	Error        string
+	Change       *AdjustChange
+	Total        int64
+	Progress     int64
+}
+
+// AdjustChange 整理单个包时删除的内容及 dist-tags 的变化
+type AdjustChange struct {
+	Name string `json:"name"`
+	// 本地没有 tarball 而被删除的版本
+	RemovedVersions []string `json:"removedVersions"`
+	// 被删除的 time 条目，以及按剩余版本重新计算后发生变化的条目（created、modified）
+	RemovedTime        []string        `json:"removedTime"`
+	UpdatedTime        []string        `json:"updatedTime"`
+	RemovedAttachments []string        `json:"removedAttachments"`
+	RemovedDistFiles   []string        `json:"removedDistFiles"`
+	DistTags           []DistTagChange `json:"distTags"`
+	// 被清理的上游元数据字段
+	Normalized []string `json:"normalized"`
+	// 整理前后的版本及 dist-tags
+	Before PackageState `json:"before"`
+	After  PackageState `json:"after"`
+}
+
+// PackageState 包的版本（按语义化版本升序）及 dist-tags
+type PackageState struct {
+	Versions []string          `json:"versions"`
+	DistTags map[string]string `json:"distTags"`
+}
+
+func packageState(pkg *Package) PackageState {
+	state := PackageState{Versions: lo.Keys(pkg.Versions), DistTags: pkg.DistTags}
+	sortVersions(state.Versions)
+	if state.DistTags == nil {
+		state.DistTags = make(map[string]string)
+	}
+	return state
+}
+
+// Empty 整理不会修改任何内容
+func (c *AdjustChange) Empty() bool {
+	return len(c.RemovedVersions) == 0 && len(c.RemovedTime) == 0 && len(c.UpdatedTime) == 0 &&
+		len(c.RemovedAttachments) == 0 && len(c.RemovedDistFiles) == 0 && len(c.DistTags) == 0 && len(c.Normalized) == 0
+}
+
+// AdjustPlan 整理单个包的计划，Apply 之前不会写入任何文件
+type AdjustPlan struct {
+	Name   string
+	Path   string
+	Before *Package
+	After  *Package
+	Change AdjustChange
+
+	upstream *upstream.Package
+}
+
+// PlanAdjust 计算根据实际存在的发布版文件整理包目录 packagePath（包名为 name）的结果，n 不为 nil 时同时清理上游元数据
+func PlanAdjust(name, packagePath string, n *Normalizer) (*AdjustPlan, error) {
+	before, err := GetPackage(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	dists, err := GetLocalDistFiles(packagePath)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "获取获取本地依赖包发布版失败：%s", packagePath)
+	}
+	after := before.clone()
+	plan := &AdjustPlan{Name: name, Path: packagePath, Before: before, After: after}
+	plan.upstream = adjustPackage(after, dists)
+	normalized := n.Normalize(after)
+
+	plan.Change = AdjustChange{
+		Name:               name,
+		RemovedVersions:    removedKeys(before.Versions, after.Versions),
+		RemovedTime:        removedKeys(before.Time, after.Time),
+		UpdatedTime:        make([]string, 0),
+		RemovedAttachments: removedKeys(before.Attachments, after.Attachments),
+		RemovedDistFiles:   removedKeys(before.DistFiles, after.DistFiles),
+		DistTags:           diffDistTags(before.DistTags, after.DistTags),
+		Normalized:         normalized,
+		Before:             packageState(before),
+		After:              packageState(after),
+	}
+	for k, v := range after.Time {
+		if old, ok := before.Time[k]; ok && old != v {
+			plan.Change.UpdatedTime = append(plan.Change.UpdatedTime, k)
+		}
+	}
+	sort.Strings(plan.Change.UpdatedTime)
+	sortVersions(plan.Change.RemovedVersions)
+	sortVersions(plan.Change.RemovedTime)
+	return plan, nil
+}
+
+// removedKeys 返回 before 中存在而 after 中不存在的 key，按字符串排序
+func removedKeys[V any](before, after map[string]V) []string {
+	keys := make([]string, 0)
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Apply 写入整理后的 package.json 并记录被删除的上游版本，没有任何修改时不写入。
+// snap 不为 nil 时在修改前记录包的原有状态
+func (p *AdjustPlan) Apply(snap *snapshot.Snapshot) error {
+	if p.Change.Empty() {
+		return nil
+	}
+	if snap != nil {
+		if err := snap.Record(p.Name, p.Path, nil); err != nil {
+			return err
+		}
+	}
+	if err := savePackage(p.Path, p.After); err != nil {
+		return err
+	}
+	if err := upstream.Merge(p.upstream, lo.Keys(p.After.Versions)); err != nil {
+		return errors.WithMessagef(err, "记录上游版本失败：%s", p.Name)
+	}
+	return nil
+}
+
+// AdjustStorage 整理 storage 中的包，每处理完一个包向 channel 发送一条消息，处理结束后关闭 channel。
+// packages 不为空时只整理这些包，否则整理所有包；dryRun 为 true 时只生成修改明细，不写入文件；
+// snap 不为 nil 时在修改前记录包的原有状态。同时处理的包数量由 opts.Concurrency 控制，n 不为 nil 时同时清理上游元数据
+func AdjustStorage(ctx context.Context, opts WorkerOptions, n *Normalizer, packages []string, dryRun bool, snap *snapshot.Snapshot, channel chan<- AjustMessage) error {
+	defer close(channel)
+
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+
+	names := packages
+	if len(names) == 0 {
+		if names, err = ListPackageDirs(storagePath); err != nil {
+			return err
+		}
+	}
+
+	var (
+		total    = int64(len(names))
+		progress int64
+		failures int64
+	)
+	runPool(ctx, opts, len(names), func(i int) {
+		name := names[i]
+		msg := AjustMessage{Pkg: name, AdjustResult: "unchanged", Total: total}
+
+		err := ctx.Err()
+		if err == nil {
+			var plan *AdjustPlan
+			if plan, err = planStoragePackage(storagePath, name, n, len(packages) > 0); err == nil && plan != nil && !plan.Change.Empty() {
+				msg.AdjustResult, msg.Change = "adjusted", &plan.Change
+				if !dryRun {
+					err = plan.Apply(snap)
+				}
+			}
+		}
+		if err != nil {
+			atomic.AddInt64(&failures, 1)
+			msg.AdjustResult = "fail"
+			msg.Error = err.Error()
+		}
+		msg.Progress = atomic.AddInt64(&progress, 1)
+		channel <- msg
+	})
+
+	if err = ctx.Err(); err != nil {
+		return errors.Wrap(err, "整理已取消")
+	}
+	if failures > 0 {
+		return errors.Errorf("%d/%d 个包整理失败", failures, total)
+	}
+	return nil
+}
+
+// planStoragePackage 计算整理 storage 中的包 name 的结果。没有 package.json 的目录（如只有 tgz 的残留目录）返回 nil，
+// required 为 true 时（指定了要整理的包）返回错误
+func planStoragePackage(storagePath, name string, n *Normalizer, required bool) (*AdjustPlan, error) {
+	if !validPackageName(name) {
+		return nil, errors.Errorf("非法的包名：%s", name)
+	}
+	pkgPath := filepath.Join(storagePath, name)
+	if !utils.PathExists(filepath.Join(pkgPath, "package.json")) {
+		if required {
+			return nil, errors.New("包不存在：" + name)
+		}
+		return nil, nil
+	}
+	return PlanAdjust(name, pkgPath, n)
+}
+
+// ScopePackages 列出 storage 中 scope 下的所有包（只包含有 package.json 的目录），返回 @scope/name 形式的包名
+func ScopePackages(scope string) ([]string, error) {
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+	scopePath := filepath.Join(storagePath, scope)
+	entries, err := os.ReadDir(scopePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取 scope 目录失败：%s", scopePath)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && utils.PathExists(filepath.Join(scopePath, entry.Name(), "package.json")) {
+			names = append(names, scope+"/"+entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// AdjustPackage 根据实际存在的发布版文件整理包目录下的 package.json，scope 目录会整理其中的每个包，返回每个包的修改明细。
+// n 不为 nil 时同时清理上游元数据，dryRun 为 true 时只生成修改明细，不写入文件。被删除的版本记录为上游存在但未同步的版本
+func AdjustPackage(packagePath string, n *Normalizer, dryRun bool) ([]AdjustChange, error) {
+	changes := make([]AdjustChange, 0)
+	// 不是文件夹则忽略
+	if !utils.IsDir(packagePath) {
+		return changes, nil
+	}
+	if !utils.PathExists(filepath.Join(packagePath, "package.json")) {
+		// scope 目录，整理其中的每个包
+		dirs, err := os.ReadDir(packagePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取子包目录失败：%s", packagePath)
+		}
+		for _, dir := range dirs {
+			if !dir.IsDir() {
+				continue
+			}
+			sub, err := AdjustPackage(filepath.Join(packagePath, dir.Name()), n, dryRun)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, sub...)
+		}
+		return changes, nil
+	}
+	plan, err := PlanAdjust(packageName(packagePath), packagePath, n)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		if err = plan.Apply(nil); err != nil {
+			return nil, err
+		}
+	}
+	return append(changes, plan.Change), nil
+}
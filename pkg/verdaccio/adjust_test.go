@@ -0,0 +1,78 @@
+package verdaccio
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"verda/pkg/upstream"
+)
+
+func TestPlanAdjust(t *testing.T) {
+	if err := upstream.Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	pkgPath := filepath.Join(t.TempDir(), "demo")
+	writePackageDir(t, pkgPath, &Package{
+		Name: "demo",
+		Versions: map[string]any{
+			"1.0.0": testManifest("demo", "1.0.0", nil),
+			"1.1.0": testManifest("demo", "1.1.0", nil),
+		},
+		Time: map[string]string{
+			"1.0.0": "2024-01-01T00:00:00.000Z",
+			"1.1.0": "2024-02-01T00:00:00.000Z",
+		},
+		DistTags:    map[string]string{"latest": "1.1.0"},
+		Attachments: map[string]Attachment{"demo-1.0.0.tgz": {}, "demo-1.1.0.tgz": {}},
+		DistFiles:   map[string]DistFile{"demo-1.0.0.tgz": {}, "demo-1.1.0.tgz": {}},
+	}, map[string]string{"demo-1.0.0.tgz": "1.0.0"})
+
+	plan, err := PlanAdjust("demo", pkgPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 本地缺少 tarball 的 1.1.0 被删除，latest 回退到剩余的版本
+	want := AdjustChange{
+		Name:               "demo",
+		RemovedVersions:    []string{"1.1.0"},
+		RemovedTime:        []string{"1.1.0"},
+		RemovedAttachments: []string{"demo-1.1.0.tgz"},
+		RemovedDistFiles:   []string{"demo-1.1.0.tgz"},
+		DistTags:           []DistTagChange{{Tag: "latest", From: "1.1.0", To: "1.0.0"}},
+		Before:             PackageState{Versions: []string{"1.0.0", "1.1.0"}, DistTags: map[string]string{"latest": "1.1.0"}},
+		After:              PackageState{Versions: []string{"1.0.0"}, DistTags: map[string]string{"latest": "1.0.0"}},
+	}
+	got := plan.Change
+	got.UpdatedTime, got.Normalized = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("change = %+v, want %+v", got, want)
+	}
+
+	// Apply 之前不写入文件
+	if pkg, err := GetPackage(pkgPath); err != nil || len(pkg.Versions) != 2 {
+		t.Fatalf("Apply 之前 package.json 不应修改：%v", err)
+	}
+	if err = plan.Apply(nil); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := GetPackage(pkgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pkg.Versions["1.1.0"]; ok || pkg.DistTags["latest"] != "1.0.0" {
+		t.Errorf("versions = %v, dist-tags = %v", pkg.Versions, pkg.DistTags)
+	}
+	// 被删除的版本记录为上游存在但未同步的版本
+	seen, err := upstream.Get("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen.Versions) != 1 || seen.Versions[0].Version != "1.1.0" {
+		t.Errorf("upstream versions = %+v", seen.Versions)
+	}
+
+	// 再次整理没有任何修改
+	if plan, err = PlanAdjust("demo", pkgPath, nil); err != nil || !plan.Change.Empty() {
+		t.Errorf("second PlanAdjust() = %+v, %v", plan.Change, err)
+	}
+}
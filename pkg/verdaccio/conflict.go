@@ -0,0 +1,242 @@
+package verdaccio
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"verda/pkg/snapshot"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+)
+
+// ConflictPolicy 补丁包中的版本与 storage 中已有版本内容不一致时的处理策略
+type ConflictPolicy string
+
+const (
+	// ConflictReject 保留本地版本，跳过补丁包中冲突的版本，其他版本正常合并
+	ConflictReject ConflictPolicy = "reject"
+	// ConflictQuarantine 与 reject 相同，同时将冲突版本的 tarball 和元数据隔离保存，便于排查
+	ConflictQuarantine ConflictPolicy = "quarantine"
+	// ConflictFail 存在冲突时整个包都不合并
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy 解析冲突处理策略
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case ConflictReject, ConflictQuarantine, ConflictFail:
+		return p, nil
+	}
+	return "", errors.Errorf("未知的冲突处理策略 %q，可选值：reject、quarantine、fail", s)
+}
+
+// PatchOptions patch 时的选项
+type PatchOptions struct {
+	WorkerOptions
+	ConflictPolicy ConflictPolicy
+	// 冲突版本的隔离目录，仅在 ConflictQuarantine 策略下使用
+	QuarantineDir string
+	// 修改每个包之前记录其原有状态，用于回滚，为 nil 时不记录
+	Snapshot *snapshot.Snapshot
+	// 将合并结果中的公网 registry 地址替换为内网地址，为 nil 时不替换
+	Rewriter *Rewriter
+	// 清理只对上游有意义的元数据，为 nil 时不清理
+	Normalizer *Normalizer
+	// 准入策略，为 nil 时不检查
+	Policy *Policy
+	// 受保护的包，为 nil 时不检查
+	Protection *Protection
+	// 导入时重命名包，为 nil 时不重命名
+	Renamer *Renamer
+}
+
+// VersionConflict 补丁包中的版本与本地已有版本不一致
+type VersionConflict struct {
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	// 不一致的内容：shasum、integrity、manifest、tarball
+	Reasons []string `json:"reasons"`
+	// 本地及补丁包中 tarball 的 sha1，未能计算时为空
+	LocalShasum    string `json:"localShasum,omitempty"`
+	IncomingShasum string `json:"incomingShasum,omitempty"`
+}
+
+// detectConflicts 对比补丁包与本地已存在的版本，before 为 nil 表示本地不存在该包，srcFile 获取补丁包中文件的路径。
+// 只有本地存在对应 tarball 的版本才会比较元数据，本地缺少文件的版本在整理时会被删除，直接使用补丁包中的即可
+func detectConflicts(name, targetPath string, src, before *Package, srcFile func(string) string, opts WorkerOptions) ([]VersionConflict, error) {
+	conflicts := make([]VersionConflict, 0)
+	for version, incoming := range src.Versions {
+		filename := DistFilename(name, version)
+		localFile := filepath.Join(targetPath, filename)
+		if !utils.PathExists(localFile) {
+			continue
+		}
+
+		conflict := VersionConflict{Version: version, Filename: filename, Reasons: make([]string, 0)}
+		if before != nil {
+			if local, ok := before.Versions[version]; ok {
+				localDist, incomingDist := manifestDist(local), manifestDist(incoming)
+				for _, field := range []string{"shasum", "integrity"} {
+					if l, i := localDist[field], incomingDist[field]; l != "" && i != "" && l != i {
+						conflict.Reasons = append(conflict.Reasons, field)
+					}
+				}
+				if !sameManifest(local, incoming) {
+					conflict.Reasons = append(conflict.Reasons, "manifest")
+				}
+			}
+		}
+
+		// 同名文件已存在时不会复制，需要比较文件内容
+		incomingFile := srcFile(filename)
+		if utils.PathExists(incomingFile) {
+			localShasum, _, err := opts.checksums(localFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "计算校验和失败：%s", localFile)
+			}
+			incomingShasum, _, err := opts.checksums(incomingFile)
+			if err != nil {
+				return nil, errors.Wrapf(err, "计算校验和失败：%s", incomingFile)
+			}
+			conflict.LocalShasum, conflict.IncomingShasum = localShasum, incomingShasum
+			if localShasum != incomingShasum {
+				conflict.Reasons = append(conflict.Reasons, "tarball")
+			}
+		}
+
+		if len(conflict.Reasons) > 0 {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	sortConflicts(conflicts)
+	return conflicts, nil
+}
+
+func sortConflicts(conflicts []VersionConflict) {
+	versions := make([]string, 0, len(conflicts))
+	byVersion := make(map[string]VersionConflict, len(conflicts))
+	for _, c := range conflicts {
+		versions = append(versions, c.Version)
+		byVersion[c.Version] = c
+	}
+	sortVersions(versions)
+	for i, v := range versions {
+		conflicts[i] = byVersion[v]
+	}
+}
+
+// manifestDist 获取版本元数据中的 dist 字段
+func manifestDist(manifest any) map[string]string {
+	dist := make(map[string]string)
+	m, ok := manifest.(map[string]any)
+	if !ok {
+		return dist
+	}
+	d, ok := m["dist"].(map[string]any)
+	if !ok {
+		return dist
+	}
+	for k, v := range d {
+		if s, ok := v.(string); ok {
+			dist[k] = s
+		}
+	}
+	return dist
+}
+
+// sameManifest 比较两个版本的元数据，忽略 registry 写入的字段（以 _ 开头的字段）、单独比较的 dist 字段
+// 以及发布后可以修改的 deprecated 字段
+func sameManifest(a, b any) bool {
+	ca, err1 := canonicalManifest(a)
+	cb, err2 := canonicalManifest(b)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return bytes.Equal(ca, cb)
+}
+
+func canonicalManifest(manifest any) ([]byte, error) {
+	m, ok := manifest.(map[string]any)
+	if !ok {
+		return json.Marshal(manifest)
+	}
+	stripped := make(map[string]any, len(m))
+	for k, v := range m {
+		if strings.HasPrefix(k, "_") || k == "dist" || k == "deprecated" {
+			continue
+		}
+		stripped[k] = v
+	}
+	// map 序列化时按 key 排序，结果可以直接比较
+	return json.Marshal(stripped)
+}
+
+// rejectConflicts 在合并结果中恢复冲突版本的本地元数据，本地不存在元数据的版本则从合并结果中删除
+func rejectConflicts(after, before *Package, conflicts []VersionConflict) {
+	for _, c := range conflicts {
+		restoreVersion(after, before, c.Version, c.Filename)
+	}
+}
+
+// restoreVersion 将 after 中的 version 及其文件 filename 相关字段恢复为 before 中的值，before 中不存在则删除
+func restoreVersion(after, before *Package, version, filename string) {
+	if before == nil {
+		before = &Package{}
+	}
+	if version != "" {
+		if v, ok := before.Versions[version]; ok {
+			after.Versions[version] = v
+		} else {
+			delete(after.Versions, version)
+		}
+		if t, ok := before.Time[version]; ok {
+			after.Time[version] = t
+		} else {
+			delete(after.Time, version)
+		}
+	}
+	if a, ok := before.Attachments[filename]; ok {
+		after.Attachments[filename] = a
+	} else {
+		delete(after.Attachments, filename)
+	}
+	if d, ok := before.DistFiles[filename]; ok {
+		after.DistFiles[filename] = d
+	} else {
+		delete(after.DistFiles, filename)
+	}
+}
+
+// quarantine 将冲突版本的 tarball 和元数据保存到 dir/<包名>/<版本>-<时间戳> 目录
+func quarantine(dir string, plan *PackagePlan) error {
+	stamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	for _, c := range plan.Conflicts {
+		target := filepath.Join(dir, plan.Name, c.Version+"-"+stamp)
+		if err := os.MkdirAll(target, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "无法创建隔离目录：%s", target)
+		}
+		srcFile := plan.srcFile(c.Filename)
+		if utils.PathExists(srcFile) {
+			if err := plan.Options.copyFile(srcFile, filepath.Join(target, c.Filename)); err != nil {
+				return errors.Wrapf(err, "隔离 %s 失败", c.Filename)
+			}
+		}
+		content, err := json.MarshalIndent(map[string]any{
+			"name":     plan.Name,
+			"conflict": c,
+			"incoming": plan.Src.Versions[c.Version],
+		}, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "序列化冲突版本失败")
+		}
+		if err = os.WriteFile(filepath.Join(target, "conflict.json"), content, 0644); err != nil {
+			return errors.Wrapf(err, "隔离 %s@%s 失败", plan.Name, c.Version)
+		}
+	}
+	return nil
+}
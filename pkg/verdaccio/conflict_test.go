@@ -0,0 +1,249 @@
+package verdaccio
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"verda/utils"
+)
+
+// writePackageDir 在 dir 中写入 package.json 及 files（文件名 -> 内容）
+func writePackageDir(t *testing.T, dir string, pkg *Package, files map[string]string) {
+	t.Helper()
+	pkg.initMaps()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := savePackage(dir, pkg); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testManifest 生成版本元数据，extra 中的字段会覆盖默认字段
+func testManifest(name, version string, extra map[string]any) map[string]any {
+	m := map[string]any{"name": name, "version": version}
+	for k, v := range extra {
+		m[k] = v
+	}
+	return m
+}
+
+// checksums 计算 content 的 sha1（十六进制）及 SRI 格式的 sha512
+func checksums(content string) (string, string) {
+	sha1Sum, sha512Sum := sha1.Sum([]byte(content)), sha512.Sum512([]byte(content))
+	return hex.EncodeToString(sha1Sum[:]), "sha512-" + base64.StdEncoding.EncodeToString(sha512Sum[:])
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ConflictPolicy
+		wantErr bool
+	}{
+		{in: "reject", want: ConflictReject},
+		{in: "quarantine", want: ConflictQuarantine},
+		{in: "fail", want: ConflictFail},
+		{in: "merge", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseConflictPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseConflictPolicy(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	localShasum, _ := checksums("local")
+	incomingShasum, _ := checksums("incoming")
+	dist := func(shasum string) map[string]any {
+		return map[string]any{"dist": map[string]any{"shasum": shasum}}
+	}
+
+	tests := []struct {
+		name     string
+		local    map[string]any
+		incoming map[string]any
+		// 本地及补丁包中 demo-1.0.0.tgz 的内容，为空时不创建该文件
+		localFile, incomingFile string
+		want                    []string
+	}{
+		{
+			name:      "相同版本",
+			local:     testManifest("demo", "1.0.0", dist(localShasum)),
+			incoming:  testManifest("demo", "1.0.0", dist(localShasum)),
+			localFile: "local", incomingFile: "local",
+			want: nil,
+		},
+		{
+			name:      "tarball 及 shasum 不一致",
+			local:     testManifest("demo", "1.0.0", dist(localShasum)),
+			incoming:  testManifest("demo", "1.0.0", dist(incomingShasum)),
+			localFile: "local", incomingFile: "incoming",
+			want: []string{"shasum", "tarball"},
+		},
+		{
+			name:      "元数据不一致",
+			local:     testManifest("demo", "1.0.0", map[string]any{"main": "index.js"}),
+			incoming:  testManifest("demo", "1.0.0", map[string]any{"main": "lib/index.js"}),
+			localFile: "local",
+			want:      []string{"manifest"},
+		},
+		{
+			name:      "忽略 deprecated 及 _ 开头的字段",
+			local:     testManifest("demo", "1.0.0", map[string]any{"_resolved": "a"}),
+			incoming:  testManifest("demo", "1.0.0", map[string]any{"_resolved": "b", "deprecated": "use demo2"}),
+			localFile: "local",
+			want:      nil,
+		},
+		{
+			name:         "本地缺少 tarball 时不比较",
+			local:        testManifest("demo", "1.0.0", map[string]any{"main": "index.js"}),
+			incoming:     testManifest("demo", "1.0.0", map[string]any{"main": "lib/index.js"}),
+			incomingFile: "incoming",
+			want:         nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targetPath, srcPath := t.TempDir(), t.TempDir()
+			if tt.localFile != "" {
+				writePackageDir(t, targetPath, &Package{Name: "demo"}, map[string]string{"demo-1.0.0.tgz": tt.localFile})
+			}
+			if tt.incomingFile != "" {
+				writePackageDir(t, srcPath, &Package{Name: "demo"}, map[string]string{"demo-1.0.0.tgz": tt.incomingFile})
+			}
+			before := &Package{Versions: map[string]any{"1.0.0": tt.local}}
+			src := &Package{Versions: map[string]any{"1.0.0": tt.incoming}}
+
+			srcFile := func(file string) string { return filepath.Join(srcPath, file) }
+
+			conflicts, err := detectConflicts("demo", targetPath, src, before, srcFile, WorkerOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range conflicts {
+				got = append(got, c.Reasons...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reasons = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanPackageConflictPolicy(t *testing.T) {
+	local := testManifest("demo", "1.0.0", map[string]any{"main": "index.js"})
+	incoming := testManifest("demo", "1.0.0", map[string]any{"main": "lib/index.js"})
+	added := testManifest("demo", "1.1.0", nil)
+
+	tests := []struct {
+		policy ConflictPolicy
+		// 合并结果中 1.0.0 的元数据
+		wantVersion map[string]any
+		wantErr     bool
+	}{
+		{policy: ConflictReject, wantVersion: local},
+		{policy: ConflictQuarantine, wantVersion: local},
+		{policy: ConflictFail, wantVersion: incoming, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			root := t.TempDir()
+			targetPath, srcPath := filepath.Join(root, "storage", "demo"), filepath.Join(root, "patch", "demo")
+			writePackageDir(t, targetPath, &Package{
+				Name:     "demo",
+				Versions: map[string]any{"1.0.0": local},
+				DistTags: map[string]string{"latest": "1.0.0"},
+			}, map[string]string{"demo-1.0.0.tgz": "local"})
+			writePackageDir(t, srcPath, &Package{
+				Name:     "demo",
+				Versions: map[string]any{"1.0.0": incoming, "1.1.0": added},
+				DistTags: map[string]string{"latest": "1.1.0"},
+			}, map[string]string{"demo-1.0.0.tgz": "incoming", "demo-1.1.0.tgz": "added"})
+
+			plan, err := PlanPackage(srcPath, targetPath, PatchOptions{
+				ConflictPolicy: tt.policy,
+				QuarantineDir:  filepath.Join(root, "quarantine"),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(plan.Conflicts) != 1 || plan.Conflicts[0].Version != "1.0.0" {
+				t.Fatalf("conflicts = %+v", plan.Conflicts)
+			}
+			// 没有冲突的版本正常合并
+			if _, ok := plan.After.Versions["1.1.0"]; !ok {
+				t.Errorf("1.1.0 未合并")
+			}
+			if !reflect.DeepEqual(plan.After.Versions["1.0.0"], tt.wantVersion) {
+				t.Errorf("1.0.0 = %v, want %v", plan.After.Versions["1.0.0"], tt.wantVersion)
+			}
+			if !reflect.DeepEqual(plan.Files, []string{"demo-1.1.0.tgz"}) {
+				t.Errorf("files = %v", plan.Files)
+			}
+			if !tt.wantErr {
+				return
+			}
+			if err = plan.Apply(); err == nil {
+				t.Fatal("fail 策略存在冲突时 Apply 应返回错误")
+			}
+			if utils.PathExists(filepath.Join(targetPath, "demo-1.1.0.tgz")) {
+				t.Error("fail 策略存在冲突时不应复制文件")
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "patch", "demo")
+	writePackageDir(t, srcPath, &Package{Name: "demo"}, map[string]string{"demo-1.0.0.tgz": "incoming"})
+	plan := &PackagePlan{
+		Name:      "demo",
+		SrcPath:   srcPath,
+		Src:       &Package{Versions: map[string]any{"1.0.0": testManifest("demo", "1.0.0", nil)}},
+		Conflicts: []VersionConflict{{Version: "1.0.0", Filename: "demo-1.0.0.tgz", Reasons: []string{"tarball"}}},
+	}
+	dir := filepath.Join(root, "quarantine")
+	if err := quarantine(dir, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "demo"))
+	if err != nil || len(entries) != 1 || !strings.HasPrefix(entries[0].Name(), "1.0.0-") {
+		t.Fatalf("隔离目录 = %v, %v", entries, err)
+	}
+	target := filepath.Join(dir, "demo", entries[0].Name())
+	if content, _ := os.ReadFile(filepath.Join(target, "demo-1.0.0.tgz")); string(content) != "incoming" {
+		t.Errorf("隔离的 tarball 内容 = %q", content)
+	}
+	var record struct {
+		Name     string          `json:"name"`
+		Conflict VersionConflict `json:"conflict"`
+	}
+	content, err := os.ReadFile(filepath.Join(target, "conflict.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(content, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Name != "demo" || record.Conflict.Version != "1.0.0" {
+		t.Errorf("conflict.json = %s", content)
+	}
+}
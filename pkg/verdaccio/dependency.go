@@ -1,17 +1,22 @@
 package verdaccio
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"verda/utils"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"github.com/tidwall/pretty"
 )
 
 type Attachment struct {
@@ -36,6 +41,27 @@ type Package struct {
 	Rev         string                `json:"_rev"`
 	Id          string                `json:"_id"`
 	Readme      string                `json:"readme"`
+	// 未在上面定义的顶层字段，原样保留
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// initMaps 初始化为 nil 的字段，避免向 nil map 写入
+func (p *Package) initMaps() {
+	if p.Versions == nil {
+		p.Versions = make(map[string]any)
+	}
+	if p.Time == nil {
+		p.Time = make(map[string]string)
+	}
+	if p.DistTags == nil {
+		p.DistTags = make(map[string]string)
+	}
+	if p.DistFiles == nil {
+		p.DistFiles = make(map[string]DistFile)
+	}
+	if p.Attachments == nil {
+		p.Attachments = make(map[string]Attachment)
+	}
 }
 
 type PackageSummary struct {
@@ -112,6 +138,42 @@ func GetPackage(path string) (*Package, error) {
 	return &pkg, nil
 }
 
+// savePackage 格式化 pkg 后写入 path 目录下的 package.json
+func savePackage(path string, pkg *Package) error {
+	content, err := json.Marshal(pkg)
+	if err != nil {
+		return errors.Wrapf(err, "反序列化失败")
+	}
+	// 格式化
+	content = pretty.Pretty(content)
+	packageJsonPath := filepath.Join(path, "package.json")
+	if err = os.MkdirAll(path, 0755); err != nil {
+		return errors.Wrapf(err, "无法创建目录：%s", path)
+	}
+	// 保留原有文件的权限，新文件使用 0644
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(packageJsonPath); err == nil {
+		perm = info.Mode().Perm()
+	}
+	// 先写入临时文件再重命名覆盖，避免中断时留下不完整的 package.json
+	err = utils.WriteFileAtomic(packageJsonPath, content, perm)
+	if err != nil {
+		return errors.Wrapf(err, "格式化package后写入package.json失败：%s", packageJsonPath)
+	}
+	return nil
+}
+
+// nextRev 生成新的 _rev，格式与 verdaccio 一致：<修订次数>-<16位随机十六进制>
+func nextRev(rev string) string {
+	count := 0
+	if i := strings.Index(rev, "-"); i > 0 {
+		count, _ = strconv.Atoi(rev[:i])
+	}
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return strconv.Itoa(count+1) + "-" + hex.EncodeToString(buf)
+}
+
 // GetLocalDistFiles 获取依赖包目录下的所有发布版
 func GetLocalDistFiles(path string) ([]string, error) {
 	files, err := os.ReadDir(path)
@@ -139,11 +201,18 @@ func GetVersions(dists []string) []string {
 	return versions
 }
 
+// GetSortedDistFiles 按版本号降序排序发布版文件，无法解析的版本排在最后
 func GetSortedDistFiles(dists []string) []string {
 	sort.SliceStable(dists, func(i, j int) bool {
-		preVersion := GetVersionFromDistFile(dists[i])
-		currVersion := GetVersionFromDistFile(dists[j])
-		return semver.MustParse(currVersion).LessThan(semver.MustParse(preVersion))
+		preVersion, e1 := semver.NewVersion(GetVersionFromDistFile(dists[i]))
+		currVersion, e2 := semver.NewVersion(GetVersionFromDistFile(dists[j]))
+		switch {
+		case e1 == nil && e2 == nil:
+			return currVersion.LessThan(preVersion)
+		case e1 == nil:
+			return true
+		}
+		return false
 	})
 	return dists
 }
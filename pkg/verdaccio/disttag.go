@@ -0,0 +1,118 @@
+package verdaccio
+
+import (
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/gofiber/fiber/v2/log"
+	"github.com/samber/lo"
+)
+
+// recomputeDistTags 根据实际存在的版本 versions 重新计算 name 的 dist-tags：
+// 标签指向的版本存在时保持不变；latest 指向的版本不存在时改为不高于原版本的最高稳定版本，
+// 其他标签改为同一预发布通道（如 beta、next 对应的 x.y.z-beta.n）中最新的版本，找不到时删除该标签。
+// 无法解析的版本号不参与计算，每个被移动或删除的标签都会记录日志
+func recomputeDistTags(name string, tags map[string]string, versions []string) map[string]string {
+	parsed := make(map[string]*semver.Version, len(versions))
+	for _, v := range versions {
+		if sv, err := semver.NewVersion(v); err == nil {
+			parsed[v] = sv
+		}
+	}
+
+	result := make(map[string]string, len(tags))
+	for tag, from := range tags {
+		to := from
+		if !lo.Contains(versions, from) {
+			if tag == "latest" {
+				to = fallbackLatest(from, parsed)
+			} else {
+				to = fallbackChannel(from, parsed)
+			}
+		}
+		if to != "" {
+			result[tag] = to
+		}
+		logTagMove(name, tag, from, to)
+	}
+
+	// npm 要求存在 latest，缺少时使用最高的稳定版本
+	if _, ok := result["latest"]; !ok && len(parsed) > 0 {
+		if to := fallbackLatest("", parsed); to != "" {
+			result["latest"] = to
+			logTagMove(name, "latest", "", to)
+		}
+	}
+	return result
+}
+
+func logTagMove(name, tag, from, to string) {
+	switch {
+	case from == to:
+	case to == "":
+		log.Infof("%s dist-tag %s 指向的版本 %s 不存在，已删除该标签", name, tag, from)
+	case from == "":
+		log.Infof("%s dist-tag %s 设置为 %s", name, tag, to)
+	default:
+		log.Infof("%s dist-tag %s 指向的版本 %s 不存在，已改为 %s", name, tag, from, to)
+	}
+}
+
+// fallbackLatest 优先选择不高于 from 的最高稳定版本，其次选择最高的稳定版本，没有稳定版本时选择最高的版本
+func fallbackLatest(from string, versions map[string]*semver.Version) string {
+	upper, err := semver.NewVersion(from)
+	if err != nil {
+		upper = nil
+	}
+	candidates := []func(v *semver.Version) bool{
+		func(v *semver.Version) bool { return v.Prerelease() == "" && upper != nil && !v.GreaterThan(upper) },
+		func(v *semver.Version) bool { return v.Prerelease() == "" },
+		func(v *semver.Version) bool { return true },
+	}
+	for _, match := range candidates {
+		if v := highestVersion(versions, match); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// fallbackChannel from 为预发布版本时选择同一通道中最新的版本；
+// from 为稳定版本时选择同一主版本中不高于 from 的最高稳定版本；from 无法解析时返回空
+func fallbackChannel(from string, versions map[string]*semver.Version) string {
+	target, err := semver.NewVersion(from)
+	if err != nil {
+		return ""
+	}
+	if channel := prereleaseChannel(target); channel != "" {
+		return highestVersion(versions, func(v *semver.Version) bool {
+			return prereleaseChannel(v) == channel
+		})
+	}
+	return highestVersion(versions, func(v *semver.Version) bool {
+		return v.Prerelease() == "" && v.Major() == target.Major() && !v.GreaterThan(target)
+	})
+}
+
+// prereleaseChannel 获取预发布版本的通道名，如 1.0.0-beta.3、1.0.0-beta3 均为 beta，稳定版本返回空
+func prereleaseChannel(v *semver.Version) string {
+	pre, _, _ := strings.Cut(v.Prerelease(), ".")
+	return strings.TrimRight(pre, "0123456789")
+}
+
+// highestVersion 返回 versions 中满足 match 的最高版本，没有时返回空
+func highestVersion(versions map[string]*semver.Version, match func(v *semver.Version) bool) string {
+	var (
+		highest string
+		hv      *semver.Version
+	)
+	for raw, v := range versions {
+		if !match(v) {
+			continue
+		}
+		if hv == nil || v.GreaterThan(hv) || (v.Equal(hv) && raw > highest) {
+			highest, hv = raw, v
+		}
+	}
+	return highest
+}
@@ -0,0 +1,117 @@
+package verdaccio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecomputeDistTags(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		versions []string
+		want     map[string]string
+	}{
+		{
+			name:     "标签指向的版本存在时不变",
+			tags:     map[string]string{"latest": "1.1.0", "beta": "2.0.0-beta.1"},
+			versions: []string{"1.0.0", "1.1.0", "2.0.0-beta.1", "2.0.0-beta.2"},
+			want:     map[string]string{"latest": "1.1.0", "beta": "2.0.0-beta.1"},
+		},
+		{
+			name:     "latest 被删除时改为不高于原版本的最高稳定版本",
+			tags:     map[string]string{"latest": "1.2.0"},
+			versions: []string{"1.0.0", "1.1.0", "1.3.0", "1.2.1-rc.1"},
+			want:     map[string]string{"latest": "1.1.0"},
+		},
+		{
+			name:     "latest 被删除且没有更低的稳定版本时使用最高稳定版本",
+			tags:     map[string]string{"latest": "1.0.0"},
+			versions: []string{"2.0.0", "3.0.0", "4.0.0-beta.1"},
+			want:     map[string]string{"latest": "3.0.0"},
+		},
+		{
+			name:     "latest 被删除且只有预发布版本",
+			tags:     map[string]string{"latest": "1.0.0"},
+			versions: []string{"2.0.0-alpha.1", "2.0.0-beta.1"},
+			want:     map[string]string{"latest": "2.0.0-beta.1"},
+		},
+		{
+			name:     "latest 指向无法解析的版本",
+			tags:     map[string]string{"latest": "not-a-version"},
+			versions: []string{"1.0.0", "2.0.0"},
+			want:     map[string]string{"latest": "2.0.0"},
+		},
+		{
+			name:     "缺少 latest 时补充",
+			tags:     map[string]string{"beta": "2.0.0-beta.1"},
+			versions: []string{"1.0.0", "2.0.0-beta.1"},
+			want:     map[string]string{"latest": "1.0.0", "beta": "2.0.0-beta.1"},
+		},
+		{
+			name:     "预发布标签改为同一通道中最新的版本",
+			tags:     map[string]string{"latest": "1.0.0", "beta": "2.0.0-beta.3"},
+			versions: []string{"1.0.0", "2.0.0-beta.1", "2.0.0-beta2", "2.0.0-rc.1"},
+			want:     map[string]string{"latest": "1.0.0", "beta": "2.0.0-beta2"},
+		},
+		{
+			name:     "同一通道没有版本时删除标签",
+			tags:     map[string]string{"latest": "1.0.0", "next": "2.0.0-next.1"},
+			versions: []string{"1.0.0", "2.0.0-beta.1"},
+			want:     map[string]string{"latest": "1.0.0"},
+		},
+		{
+			name:     "稳定版本标签改为同一主版本中不高于原版本的最高稳定版本",
+			tags:     map[string]string{"latest": "2.0.0", "v1": "1.5.0"},
+			versions: []string{"1.2.0", "1.4.0", "1.6.0", "2.0.0"},
+			want:     map[string]string{"latest": "2.0.0", "v1": "1.4.0"},
+		},
+		{
+			name:     "没有任何版本时删除所有标签",
+			tags:     map[string]string{"latest": "1.0.0", "beta": "2.0.0-beta.1"},
+			versions: []string{},
+			want:     map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recomputeDistTags("demo", tt.tags, tt.versions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recomputeDistTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdjustPackageRemovedLatest(t *testing.T) {
+	pkg := &Package{
+		Name: "demo",
+		Versions: map[string]any{
+			"1.0.0":        testManifest("demo", "1.0.0", nil),
+			"1.1.0":        testManifest("demo", "1.1.0", nil),
+			"2.0.0-beta.1": testManifest("demo", "2.0.0-beta.1", nil),
+		},
+		Time: map[string]string{
+			"1.0.0":        "2024-01-01T00:00:00.000Z",
+			"1.1.0":        "2024-02-01T00:00:00.000Z",
+			"2.0.0-beta.1": "2024-03-01T00:00:00.000Z",
+		},
+		DistTags: map[string]string{"latest": "1.1.0", "beta": "2.0.0-beta.1"},
+	}
+	pkg.initMaps()
+	// 本地只有 1.0.0 的 tarball，latest 指向的 1.1.0 及 beta 指向的版本都被删除
+	seen := adjustPackage(pkg, []string{"demo-1.0.0.tgz"})
+
+	if want := map[string]string{"latest": "1.0.0"}; !reflect.DeepEqual(pkg.DistTags, want) {
+		t.Errorf("dist-tags = %v, want %v", pkg.DistTags, want)
+	}
+	if _, ok := pkg.Versions["1.1.0"]; ok {
+		t.Error("本地不存在 tarball 的版本应被删除")
+	}
+	if seen == nil || len(seen.Versions) != 2 {
+		t.Errorf("upstream = %+v", seen)
+	}
+	// 整理前指向被删除版本的 dist-tags 记录为上游的 dist-tags
+	if want := map[string]string{"latest": "1.1.0", "beta": "2.0.0-beta.1"}; !reflect.DeepEqual(seen.DistTags, want) {
+		t.Errorf("upstream dist-tags = %v, want %v", seen.DistTags, want)
+	}
+}
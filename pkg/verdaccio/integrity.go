@@ -0,0 +1,82 @@
+package verdaccio
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IntegrityError 补丁包中的 tarball 与元数据中记录的校验和不一致
+type IntegrityError struct {
+	Version  string `json:"version,omitempty"`
+	Filename string `json:"filename"`
+	// 记录校验和的字段：dist.shasum、dist.integrity、_attachments.shasum、_distfiles.sha
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// verifyTarballs 计算补丁包中每个待复制 tarball 的 sha1 和 sha512，并与 package.json 中记录的校验和比较，
+// srcFile 获取补丁包中文件的路径
+func verifyTarballs(name string, src *Package, files []string, srcFile func(string) string, opts WorkerOptions) ([]IntegrityError, error) {
+	versions := make(map[string]string, len(src.Versions))
+	for v := range src.Versions {
+		versions[DistFilename(name, v)] = v
+	}
+
+	errs := make([]IntegrityError, 0)
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".tgz") {
+			continue
+		}
+		shasum, integrity, err := opts.checksums(srcFile(file))
+		if err != nil {
+			return nil, errors.Wrapf(err, "计算校验和失败：%s", file)
+		}
+
+		version := versions[file]
+		mismatch := func(field, expected, actual string) {
+			errs = append(errs, IntegrityError{Version: version, Filename: file, Field: field, Expected: expected, Actual: actual})
+		}
+		if version != "" {
+			dist := manifestDist(src.Versions[version])
+			if expected := dist["shasum"]; expected != "" && !strings.EqualFold(expected, shasum) {
+				mismatch("dist.shasum", expected, shasum)
+			}
+			if expected := dist["integrity"]; expected != "" && !matchIntegrity(expected, shasum, integrity) {
+				mismatch("dist.integrity", expected, integrity)
+			}
+		}
+		if a, ok := src.Attachments[file]; ok && a.Shasum != "" && !strings.EqualFold(a.Shasum, shasum) {
+			mismatch("_attachments.shasum", a.Shasum, shasum)
+		}
+		if d, ok := src.DistFiles[file]; ok && d.Sha != "" && !strings.EqualFold(d.Sha, shasum) {
+			mismatch("_distfiles.sha", d.Sha, shasum)
+		}
+	}
+	return errs, nil
+}
+
+// matchIntegrity 校验 SRI 格式的 integrity（可能包含多个以空格分隔的哈希），只比较 sha1 和 sha512，
+// 其他算法忽略；不包含可比较的哈希时视为匹配
+func matchIntegrity(expected, shasum, integrity string) bool {
+	sha1Bytes, _ := hex.DecodeString(shasum)
+	sha1Integrity := "sha1-" + base64.StdEncoding.EncodeToString(sha1Bytes)
+	for _, item := range strings.Fields(expected) {
+		// 去掉 SRI 的选项部分，如 sha512-xxx?foo
+		item, _, _ = strings.Cut(item, "?")
+		switch {
+		case strings.HasPrefix(item, "sha512-"):
+			if item != integrity {
+				return false
+			}
+		case strings.HasPrefix(item, "sha1-"):
+			if item != sha1Integrity {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package verdaccio
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyTarballs(t *testing.T) {
+	shasum, integrity := checksums("content")
+	otherShasum, otherIntegrity := checksums("other")
+	dist := func(fields map[string]any) map[string]any {
+		return testManifest("demo", "1.0.0", map[string]any{"dist": fields})
+	}
+
+	tests := []struct {
+		name string
+		src  *Package
+		want []string
+	}{
+		{
+			name: "校验和一致",
+			src: &Package{
+				Versions:    map[string]any{"1.0.0": dist(map[string]any{"shasum": shasum, "integrity": integrity})},
+				Attachments: map[string]Attachment{"demo-1.0.0.tgz": {Shasum: shasum}},
+				DistFiles:   map[string]DistFile{"demo-1.0.0.tgz": {Sha: shasum}},
+			},
+		},
+		{
+			name: "shasum 忽略大小写",
+			src:  &Package{Versions: map[string]any{"1.0.0": dist(map[string]any{"shasum": strings.ToUpper(shasum)})}},
+		},
+		{
+			name: "没有记录校验和",
+			src:  &Package{Versions: map[string]any{"1.0.0": testManifest("demo", "1.0.0", nil)}},
+		},
+		{
+			name: "dist.shasum 不一致",
+			src:  &Package{Versions: map[string]any{"1.0.0": dist(map[string]any{"shasum": otherShasum, "integrity": integrity})}},
+			want: []string{"dist.shasum"},
+		},
+		{
+			name: "dist.integrity 不一致",
+			src:  &Package{Versions: map[string]any{"1.0.0": dist(map[string]any{"shasum": shasum, "integrity": otherIntegrity})}},
+			want: []string{"dist.integrity"},
+		},
+		{
+			name: "_attachments 及 _distfiles 不一致",
+			src: &Package{
+				Versions:    map[string]any{"1.0.0": dist(map[string]any{"shasum": shasum})},
+				Attachments: map[string]Attachment{"demo-1.0.0.tgz": {Shasum: otherShasum}},
+				DistFiles:   map[string]DistFile{"demo-1.0.0.tgz": {Sha: otherShasum}},
+			},
+			want: []string{"_attachments.shasum", "_distfiles.sha"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srcPath := t.TempDir()
+			writePackageDir(t, srcPath, &Package{Name: "demo"}, map[string]string{"demo-1.0.0.tgz": "content"})
+
+			srcFile := func(file string) string { return filepath.Join(srcPath, file) }
+
+			errs, err := verifyTarballs("demo", tt.src, []string{"demo-1.0.0.tgz", "README.md"}, srcFile, WorkerOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range errs {
+				if e.Version != "1.0.0" || e.Filename != "demo-1.0.0.tgz" {
+					t.Errorf("error = %+v", e)
+				}
+				got = append(got, e.Field)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchIntegrity(t *testing.T) {
+	shasum, integrity := checksums("content")
+	otherShasum, otherIntegrity := checksums("other")
+	sha1Integrity := func(shasum string) string {
+		b, _ := hex.DecodeString(shasum)
+		return "sha1-" + base64.StdEncoding.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+		want     bool
+	}{
+		{name: "sha512 一致", expected: integrity, want: true},
+		{name: "sha512 不一致", expected: otherIntegrity, want: false},
+		{name: "sha1 一致", expected: sha1Integrity(shasum), want: true},
+		{name: "sha1 不一致", expected: sha1Integrity(otherShasum), want: false},
+		{name: "多个哈希均一致", expected: sha1Integrity(shasum) + " " + integrity, want: true},
+		{name: "多个哈希其中一个不一致", expected: integrity + " " + sha1Integrity(otherShasum), want: false},
+		{name: "忽略选项部分", expected: integrity + "?foo", want: true},
+		{name: "忽略其他算法", expected: "sha256-abc", want: true},
+	}
+	for _, tt := range tests {
+		if got := matchIntegrity(tt.expected, shasum, integrity); got != tt.want {
+			t.Errorf("%s: matchIntegrity(%q) = %v, want %v", tt.name, tt.expected, got, tt.want)
+		}
+	}
+}
+
+func TestPlanPackageExcludesIntegrityErrors(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "patch", "demo")
+	shasum, _ := checksums("good")
+	otherShasum, _ := checksums("other")
+	writePackageDir(t, srcPath, &Package{
+		Name: "demo",
+		Versions: map[string]any{
+			"1.0.0": testManifest("demo", "1.0.0", map[string]any{"dist": map[string]any{"shasum": shasum}}),
+			"1.1.0": testManifest("demo", "1.1.0", map[string]any{"dist": map[string]any{"shasum": otherShasum}}),
+		},
+		DistTags: map[string]string{"latest": "1.1.0"},
+	}, map[string]string{"demo-1.0.0.tgz": "good", "demo-1.1.0.tgz": "bad"})
+
+	plan, err := PlanPackage(srcPath, filepath.Join(root, "storage", "demo"), PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.IntegrityErrors) != 1 || plan.IntegrityErrors[0].Version != "1.1.0" {
+		t.Fatalf("integrity errors = %+v", plan.IntegrityErrors)
+	}
+	if _, ok := plan.After.Versions["1.1.0"]; ok {
+		t.Error("校验和不一致的版本不应合并")
+	}
+	if !reflect.DeepEqual(plan.Files, []string{"demo-1.0.0.tgz"}) {
+		t.Errorf("files = %v", plan.Files)
+	}
+	// latest 指向的版本被排除后回退到仍存在的版本
+	if latest := plan.After.DistTags["latest"]; latest != "1.0.0" {
+		t.Errorf("latest = %q, want 1.0.0", latest)
+	}
+}
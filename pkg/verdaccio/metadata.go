@@ -0,0 +1,32 @@
+package verdaccio
+
+// mergeMetadata 合并只包含 package.json 的补丁包（用于同步 dist-tags、废弃信息），只处理本地已存在的版本：
+// 合并这些版本的 deprecated 字段和 time，以及指向这些版本的 dist-tags；本地不存在的版本忽略
+func mergeMetadata(before, src *Package) *Package {
+	after := before.clone()
+	for version, incoming := range src.Versions {
+		local, ok := after.Versions[version].(map[string]any)
+		if !ok {
+			continue
+		}
+		if m, ok := incoming.(map[string]any); ok {
+			// 取消废弃时 npm 会将 deprecated 设置为空字符串
+			if deprecated, ok := m["deprecated"].(string); ok {
+				if deprecated == "" {
+					delete(local, "deprecated")
+				} else {
+					local["deprecated"] = deprecated
+				}
+			}
+		}
+		if t, ok := src.Time[version]; ok {
+			after.Time[version] = t
+		}
+	}
+	for tag, version := range src.DistTags {
+		if _, ok := after.Versions[version]; ok {
+			after.DistTags[tag] = version
+		}
+	}
+	return after
+}
@@ -0,0 +1,53 @@
+package verdaccio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeMetadata(t *testing.T) {
+	before := &Package{
+		Name: "demo",
+		Versions: map[string]any{
+			"1.0.0": testManifest("demo", "1.0.0", map[string]any{"deprecated": "old"}),
+			"1.1.0": testManifest("demo", "1.1.0", nil),
+		},
+		Time:     map[string]string{"1.0.0": "2024-01-01T00:00:00.000Z"},
+		DistTags: map[string]string{"latest": "1.0.0"},
+	}
+	before.initMaps()
+	src := &Package{
+		Name: "demo",
+		Versions: map[string]any{
+			// 取消废弃
+			"1.0.0": testManifest("demo", "1.0.0", map[string]any{"deprecated": ""}),
+			"1.1.0": testManifest("demo", "1.1.0", map[string]any{"deprecated": "use 2.x"}),
+			// 本地不存在的版本忽略
+			"2.0.0": testManifest("demo", "2.0.0", nil),
+		},
+		Time:     map[string]string{"1.0.0": "2024-01-02T00:00:00.000Z", "2.0.0": "2024-03-01T00:00:00.000Z"},
+		DistTags: map[string]string{"latest": "1.1.0", "next": "2.0.0"},
+	}
+	src.initMaps()
+
+	after := mergeMetadata(before, src)
+	if _, ok := after.Versions["2.0.0"]; ok {
+		t.Error("本地不存在的版本不应合并")
+	}
+	if _, ok := after.Versions["1.0.0"].(map[string]any)["deprecated"]; ok {
+		t.Error("deprecated 为空字符串时应取消废弃")
+	}
+	if got := after.Versions["1.1.0"].(map[string]any)["deprecated"]; got != "use 2.x" {
+		t.Errorf("1.1.0 deprecated = %v", got)
+	}
+	if want := map[string]string{"latest": "1.1.0"}; !reflect.DeepEqual(after.DistTags, want) {
+		t.Errorf("dist-tags = %v, want %v", after.DistTags, want)
+	}
+	if want := map[string]string{"1.0.0": "2024-01-02T00:00:00.000Z"}; !reflect.DeepEqual(after.Time, want) {
+		t.Errorf("time = %v, want %v", after.Time, want)
+	}
+	// 不修改整理前的包
+	if _, ok := before.Versions["1.0.0"].(map[string]any)["deprecated"]; !ok || before.DistTags["latest"] != "1.0.0" {
+		t.Error("mergeMetadata 不应修改 before")
+	}
+}
@@ -0,0 +1,128 @@
+package verdaccio
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// DefaultStripFields 未配置时默认删除的顶层字段
+var DefaultStripFields = []string{"users"}
+
+// coreFields verdaccio 依赖的顶层字段，不允许删除
+var coreFields = []string{"name", "versions", "time", "dist-tags", "_uplinks", "_distfiles", "_attachments", "_rev", "_id"}
+
+// packageFields Package 中已定义的顶层字段
+var packageFields = []string{"name", "versions", "time", "users", "dist-tags", "_uplinks", "_distfiles", "_attachments", "_rev", "_id", "readme"}
+
+// ValidateStripFields 检查需要删除的字段，不允许删除 verdaccio 依赖的字段
+func ValidateStripFields(fields []string) error {
+	for _, field := range fields {
+		if lo.Contains(coreFields, field) {
+			return errors.Errorf("不允许删除字段：%s", field)
+		}
+	}
+	return nil
+}
+
+// Normalizer 清理从外网 verdaccio 复制过来、只对上游有意义的元数据，
+// 避免内网 verdaccio 向不存在的 uplink 重新校验
+type Normalizer struct {
+	// 需要删除的顶层字段
+	StripFields []string
+}
+
+// Normalize 重置 _uplinks、重新生成 _rev 并删除 StripFields 中的字段，返回被清理的字段（不含 _rev）
+func (n *Normalizer) Normalize(pkg *Package) []string {
+	fields := make([]string, 0)
+	if n == nil {
+		return fields
+	}
+	if !isEmptyObject(pkg.Uplinks) {
+		fields = append(fields, "_uplinks")
+	}
+	pkg.Uplinks = map[string]any{}
+
+	for _, field := range n.StripFields {
+		switch field {
+		case "users":
+			if !isEmptyObject(pkg.Users) {
+				pkg.Users = map[string]any{}
+				fields = append(fields, field)
+			}
+		case "readme":
+			if pkg.Readme != "" {
+				pkg.Readme = ""
+				fields = append(fields, field)
+			}
+		default:
+			if _, ok := pkg.Extra[field]; ok {
+				delete(pkg.Extra, field)
+				fields = append(fields, field)
+			}
+		}
+	}
+	pkg.Rev = nextRev(pkg.Rev)
+
+	sort.Strings(fields)
+	return fields
+}
+
+func isEmptyObject(v any) bool {
+	if v == nil {
+		return true
+	}
+	m, ok := v.(map[string]any)
+	return ok && len(m) == 0
+}
+
+// UnmarshalJSON 解析 package.json，未在 Package 中定义的顶层字段保存在 Extra 中
+func (p *Package) UnmarshalJSON(data []byte) error {
+	type plain Package
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	for _, field := range packageFields {
+		delete(fields, field)
+	}
+	p.Extra = nil
+	if len(fields) > 0 {
+		p.Extra = fields
+	}
+	return nil
+}
+
+// MarshalJSON 序列化 package.json，Extra 中的字段按字段名排序追加在已定义字段之后
+func (p Package) MarshalJSON() ([]byte, error) {
+	type plain Package
+	content, err := json.Marshal(plain(p))
+	if err != nil || len(p.Extra) == 0 {
+		return content, err
+	}
+
+	keys := make([]string, 0, len(p.Extra))
+	for k := range p.Extra {
+		if !lo.Contains(packageFields, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(content[:len(content)-1])
+	for _, k := range keys {
+		key, _ := json.Marshal(k)
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(p.Extra[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
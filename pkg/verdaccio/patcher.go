@@ -1,154 +1,107 @@
 package verdaccio
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync/atomic"
+	"verda/pkg/upstream"
 	"verda/utils"
 
+	"github.com/gofiber/fiber/v2/log"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
-	"github.com/tidwall/pretty"
 )
 
 type PatchMessage struct {
 	Pkg         string
 	PatchResult string
-	Total       int64
-	Progress    int64
+	Error       string
+	// 有意跳过（如受保护的包）的原因，不算失败
+	Warning  string
+	Change   *PackageChange
+	Total    int64
+	Progress int64
 }
 
-func PatchStorage(src string, channel chan<- PatchMessage) error {
-	needPatchPackages, err := os.ReadDir(src)
-	if err != nil {
-		return errors.Wrapf(err, "读取patch storage目录失败：%s", src)
-
-	}
+// PatchStorage 将补丁目录 src 中的包合并到 storage，每处理完一个包向 channel 发送一条消息，
+// 处理结束后关闭 channel。同时处理的包数量由 opts.Concurrency 控制，ctx 取消后不再处理新的包，存在失败的包时返回错误。
+// 存在校验和不一致的 tarball 的包结果为 corrupt，存在版本冲突但按策略跳过了冲突版本的包结果为 conflict，
+// 存在被准入策略拒绝的版本的包结果为 rejected，试图修改受保护的包（内网发布的包）的结果为 protected。
+// opts.Renamer 不为 nil 时包合并到重命名后的包名下，消息中的包名为重命名后的包名
+func PatchStorage(ctx context.Context, src string, opts PatchOptions, channel chan<- PatchMessage) error {
+	defer close(channel)
 
-	storagePath, err := GetStoragePath()
+	names, err := ListPackageDirs(src)
 	if err != nil {
-		return errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+		return err
 	}
-
-	var total = int64(len(needPatchPackages))
-	var progress int64 = 0
-	for _, pkg := range needPatchPackages {
-		println("pkg=" + pkg.Name())
-		srcPkg, targetPkg := filepath.Join(src, pkg.Name()), filepath.Join(storagePath, pkg.Name())
-		println("src=" + srcPkg + ", target=" + targetPkg)
-
-		pkg := pkg
-
-		go func() {
-			err = PatchPackage(srcPkg, targetPkg)
-
-			atomic.AddInt64(&progress, 1)
-			if err != nil {
-				channel <- PatchMessage{
-					Pkg:         pkg.Name(),
-					PatchResult: "fail",
-					Total:       total,
-					Progress:    progress,
-				}
-			} else {
-				channel <- PatchMessage{
-					Pkg:         pkg.Name(),
-					PatchResult: "success",
-					Total:       total,
-					Progress:    progress,
-				}
-			}
-		}()
-
+	targets, err := opts.Renamer.Targets(names)
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-type AjustMessage struct {
-	Pkg          string
-	AdjustResult string
-	Total        int64
-	Progress     int64
-}
 
-func AdjustStorage(channel chan<- AjustMessage) error {
 	storagePath, err := GetStoragePath()
 	if err != nil {
 		return errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
 	}
 
-	packages, err := os.ReadDir(storagePath)
-	if err != nil {
-		return errors.Wrapf(err, "读取storage目录失败：%s", storagePath)
-	}
-
-	var total = int64(len(packages))
-	var progress int64 = 0
-	for _, pkg := range packages {
-		packagePath := filepath.Join(storagePath, pkg.Name())
-
-		pkg := pkg
-
-		go func() {
-			err = AdjustPackage(packagePath)
-
-			atomic.AddInt64(&progress, 1)
-			if err != nil {
-				channel <- AjustMessage{
-					Pkg:          pkg.Name(),
-					AdjustResult: "fail",
-					Total:        total,
-					Progress:     progress,
-				}
-			} else {
-				channel <- AjustMessage{
-					Pkg:          pkg.Name(),
-					AdjustResult: "success",
-					Total:        total,
-					Progress:     progress,
-				}
-			}
-		}()
-	}
-	return nil
-}
-
-func AdjustPackage(packagePath string) error {
 	var (
-		pkg   *Package
-		dists []string
-		err   error
+		total    = int64(len(names))
+		progress int64
+		failures int64
 	)
-	// 不是文件夹则忽略
-	if !utils.IsDir(packagePath) {
-		return nil
-	}
-	pkg, err = GetPackage(packagePath)
-	if err != nil {
-		// 读取子包目录
-		if dirs, err := os.ReadDir(packagePath); err == nil {
-			// 如果有子包
-			if len(dirs) > 0 {
-				for _, dir := range dirs {
-					srcPkg := filepath.Join(packagePath, dir.Name())
-					if err = AdjustPackage(srcPkg); err != nil {
-						fmt.Printf("patch package [%s] 失败 <%s>\n", srcPkg, err.Error())
+	runPool(ctx, opts.WorkerOptions, len(names), func(i int) {
+		name := targets[i]
+		srcPkg, targetPkg := filepath.Join(src, names[i]), filepath.Join(storagePath, name)
+		msg := PatchMessage{Pkg: name, PatchResult: "success", Total: total}
+
+		err := ctx.Err()
+		if err == nil {
+			var plan *PackagePlan
+			if plan, err = PlanPackage(srcPkg, targetPkg, opts); err == nil {
+				change := plan.Change()
+				msg.Change = &change
+				if err = plan.Apply(); err == nil {
+					switch {
+					case plan.Protected != nil:
+						msg.PatchResult = "protected"
+						msg.Warning = plan.Protected.Message(name)
+						log.Warnf("补丁包试图修改受保护的包：%s", msg.Warning)
+					case len(plan.IntegrityErrors) > 0:
+						msg.PatchResult = "corrupt"
+					case len(plan.Conflicts) > 0:
+						msg.PatchResult = "conflict"
+					case len(plan.Rejected) > 0:
+						msg.PatchResult = "rejected"
 					}
 				}
 			}
-			return nil
-		} else {
-			return errors.Wrapf(err, "读取子包目录失败：%s", packagePath)
 		}
+		if err != nil {
+			atomic.AddInt64(&failures, 1)
+			msg.PatchResult = "fail"
+			msg.Error = err.Error()
+		}
+		msg.Progress = atomic.AddInt64(&progress, 1)
+		channel <- msg
+	})
+
+	if err = ctx.Err(); err != nil {
+		return errors.Wrap(err, "打补丁已取消")
 	}
-	dists, err = GetLocalDistFiles(packagePath)
-	if err != nil {
-		return errors.WithMessagef(err, "获取获取本地依赖包发布版失败：%s", packagePath)
+	if failures > 0 {
+		return errors.Errorf("%d/%d 个包打补丁失败", failures, total)
 	}
+	return nil
+}
+
+// adjustPackage 根据实际存在的发布版文件 dists 整理 pkg 的各个字段，返回被删除的版本（上游存在但本地没有 tarball）
+// 及整理前指向这些版本的 dist-tags
+func adjustPackage(pkg *Package, dists []string) *upstream.Package {
 	localVersions := GetVersions(dists)
+	seen := collectUpstream(pkg, localVersions)
 	// 更新versions字段
 	newVersions := make(map[string]any)
 	for k, v := range pkg.Versions {
@@ -191,49 +144,18 @@ func AdjustPackage(packagePath string) error {
 	}
 	pkg.Attachments = newAttachments
 
-	// 更新 dist-tags字段（由于无法从版本号中判断出除latest之外的标签，其他标签的更新会有问题）
-	newDistTags := make(map[string]string)
-	for k, v := range pkg.DistTags {
-		if k == "latest" {
-			newDistTags[k] = GetVersionFromDistFile(GetLatestDist(dists))
-		} else if lo.Contains(localVersions, v) {
-			newDistTags[k] = v
-		}
-	}
-	pkg.DistTags = newDistTags
-
-	// 格式化后保存
-	content, err := json.Marshal(pkg)
-	if err != nil {
-		return errors.Wrapf(err, "反序列化失败")
-	}
-	// 格式化
-	content = pretty.Pretty(content)
-	packageJsonPath := filepath.Join(packagePath, "package.json")
-	// 覆盖内容
-	err = os.WriteFile(packageJsonPath, content, 0777)
-	if err != nil {
-		return errors.Wrapf(err, "格式化package后写入package.json失败：%s", packageJsonPath)
-	}
-
-	return nil
+	// 更新 dist-tags字段
+	pkg.DistTags = recomputeDistTags(pkg.Name, pkg.DistTags, localVersions)
+	return seen
 }
 
-func mergePackageJson(src, dest string) (*Package, error) {
-	var (
-		srcPkg  *Package
-		destPkg *Package
-		err     error
-	)
-	srcPkg, err = GetPackage(src)
-	if err != nil {
-		return nil, errors.WithMessagef(err, "读取 package.json 失败：%s", src)
-	}
-
-	destPkg, err = GetPackage(dest)
+func mergePackageJson(srcPkg *Package, dest string) (*Package, error) {
+	destPkg, err := GetPackage(dest)
 	if err != nil {
 		return nil, errors.WithMessagef(err, "读取 package.json 失败：%s", dest)
 	}
+	destPkg.initMaps()
+
 	// 合并 versiongs
 	for k, v := range srcPkg.Versions {
 		destPkg.Versions[k] = v
@@ -262,7 +184,7 @@ func mergePackageJson(src, dest string) (*Package, error) {
 	return destPkg, nil
 }
 
-func PatchPackage(srcPkgPath, targetPkgPath string) error {
+func PatchPackage(srcPkgPath, targetPkgPath string, opts PatchOptions) error {
 	// 不是文件夹则忽略
 	if !utils.IsDir(srcPkgPath) {
 		return nil
@@ -271,7 +193,6 @@ func PatchPackage(srcPkgPath, targetPkgPath string) error {
 		return nil
 	}
 
-	var err error
 	if strings.HasPrefix(filepath.Base(srcPkgPath), "@") {
 		subPackages, err := os.ReadDir(srcPkgPath)
 		if err != nil {
@@ -279,74 +200,17 @@ func PatchPackage(srcPkgPath, targetPkgPath string) error {
 		}
 		for _, pkg := range subPackages {
 			a, b := filepath.Join(srcPkgPath, pkg.Name()), filepath.Join(targetPkgPath, pkg.Name())
-			err := PatchPackage(a, b)
+			err := PatchPackage(a, b, opts)
 			if err != nil {
-				fmt.Printf("patch [%s -> %s] 失败 <%v>\n", filepath.Base(a), pkg, err)
+				log.Errorf("patch [%s -> %s] 失败 <%v>", filepath.Base(a), b, err)
 			}
 		}
 		return nil
 	}
 
-	// 如果已经存在
-	if utils.PathExists(targetPkgPath) {
-		// 合并package.json
-		pkg, err := mergePackageJson(srcPkgPath, targetPkgPath)
-		if err != nil {
-			return errors.WithMessagef(err, "合并 package.json失败: [%s -> %s]", srcPkgPath, targetPkgPath)
-		}
-		// 转为字符串并格式化
-		content, err := json.Marshal(pkg)
-		if err != nil {
-			return errors.Wrapf(err, "反序列化失败")
-		}
-		// 格式化
-		content = pretty.Pretty(content)
-		// 重新保存
-		packageJsonPath := filepath.Join(targetPkgPath, "package.json")
-		err = os.WriteFile(packageJsonPath, content, 0777)
-		if err != nil {
-			return errors.Wrapf(err, "格式化package后写入package.json失败：%s", packageJsonPath)
-		}
-
-		// 读取依赖包目录，获取所有版本文件
-		dists, err := os.ReadDir(srcPkgPath)
-		if err != nil {
-			return errors.Wrapf(err, "读取目录失败：%s", srcPkgPath)
-		}
-		// 遍历依赖包的所有版本
-		for _, dist := range dists {
-			if dist.Name() == "package.json" {
-				continue
-			}
-			// 判断该版本是否已存在
-			src := filepath.Join(srcPkgPath, dist.Name())
-			dest := filepath.Join(targetPkgPath, dist.Name())
-			if utils.PathExists(dest) {
-				continue
-			}
-			// 如果不存在就复制到目标目录
-			err = utils.Copy(src, dest)
-			if err != nil {
-				return errors.Wrapf(err, "复制 %s 失败", dist.Name())
-			}
-		}
-
-		// 整理package.json
-		err = AdjustPackage(targetPkgPath)
-		if err != nil {
-			return errors.WithMessagef(err, "整理 package.json 失败：%s", filepath.Join(targetPkgPath, "package.json"))
-		}
-	} else {
-		// 如果不存在，拷贝到目标目录
-		err = utils.Copy(srcPkgPath, targetPkgPath)
-		if err != nil {
-			return errors.Wrapf(err, "复制 %s 失败", filepath.Base(srcPkgPath))
-		}
-
-		err = AdjustPackage(targetPkgPath)
-		if err != nil {
-			return errors.WithMessagef(err, "整理 package.json 失败：%s", filepath.Join(targetPkgPath, "package.json"))
-		}
+	plan, err := PlanPackage(srcPkgPath, targetPkgPath, opts)
+	if err != nil {
+		return err
 	}
-	return err
+	return plan.Apply()
 }
@@ -0,0 +1,458 @@
+package verdaccio
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"verda/pkg/upstream"
+	"verda/utils"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// PackagePlan 描述 patch 对单个包将要进行的修改，Apply 之前不会写入任何文件
+type PackagePlan struct {
+	Name       string
+	SrcPath    string
+	TargetPath string
+	// 补丁包中的 package.json
+	Src *Package
+	// 目标包已存在时的 package.json，否则为 nil
+	Before *Package
+	// 合并、整理后将要写入的 package.json
+	After *Package
+	// 需要复制到目标目录的文件
+	Files []string
+	Bytes int64
+	// 重命名后的文件名对应的补丁包中的文件名，只包含文件名发生变化的文件
+	srcFiles map[string]string
+	// 包被重命名时为补丁包中的原包名
+	RenamedFrom string
+	// 依赖改为别名的明细
+	Aliases []RewriteChange
+	// 补丁包中该包只有 package.json，只同步已有版本的 dist-tags、deprecated 和 time
+	MetadataOnly bool
+	// 与本地已有版本内容不一致的版本
+	Conflicts []VersionConflict
+	// 校验和与元数据不一致的 tarball，这些文件不会被复制，对应的版本也不会合并
+	IntegrityErrors []IntegrityError
+	// 被准入策略拒绝的版本，这些版本及其文件不会合并
+	Rejected []PolicyViolation
+	// registry 地址替换明细
+	Rewrites []RewriteChange
+	// 被清理的上游元数据字段
+	Normalized []string
+	// 补丁包试图修改受保护的包，不为 nil 时不会修改该包
+	Protected *ProtectionViolation
+	// 上游存在但补丁包中没有 tarball 的版本，Apply 后记录
+	Upstream *upstream.Package
+	Options  PatchOptions
+}
+
+type DistTagChange struct {
+	Tag  string `json:"tag"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// PackageChange 单个包的变更明细
+type PackageChange struct {
+	Name             string               `json:"name"`
+	RenamedFrom      string               `json:"renamedFrom,omitempty"`
+	New              bool                 `json:"new"`
+	MetadataOnly     bool                 `json:"metadataOnly"`
+	NewVersions      []string             `json:"newVersions"`
+	ExistingVersions []string             `json:"existingVersions"`
+	DistTags         []DistTagChange      `json:"distTags"`
+	ChangedFields    []string             `json:"changedFields"`
+	Files            []string             `json:"files"`
+	Bytes            int64                `json:"bytes"`
+	Conflicts        []VersionConflict    `json:"conflicts"`
+	IntegrityErrors  []IntegrityError     `json:"integrityErrors"`
+	Rejected         []PolicyViolation    `json:"rejected"`
+	Rewrites         []RewriteChange      `json:"rewrites"`
+	Aliases          []RewriteChange      `json:"aliases"`
+	Normalized       []string             `json:"normalized"`
+	Protected        *ProtectionViolation `json:"protected,omitempty"`
+	Error            string               `json:"error,omitempty"`
+}
+
+// PatchReport 预览 patch 时生成的变更报告
+type PatchReport struct {
+	Packages   []PackageChange `json:"packages"`
+	TotalBytes int64           `json:"totalBytes"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// packageName 根据包目录获取包名，scope 包返回 @scope/name
+func packageName(pkgPath string) string {
+	name, parent := filepath.Base(pkgPath), filepath.Base(filepath.Dir(pkgPath))
+	if strings.HasPrefix(parent, "@") {
+		return parent + "/" + name
+	}
+	return name
+}
+
+// ListPackageDirs 列出补丁目录（或 storage）中的所有包名（包含 scope 目录内的包）
+func ListPackageDirs(src string) ([]string, error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取patch storage目录失败：%s", src)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(entry.Name(), "@") {
+			names = append(names, entry.Name())
+			continue
+		}
+		scopeDir := filepath.Join(src, entry.Name())
+		subs, err := os.ReadDir(scopeDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取目录失败：%s", scopeDir)
+		}
+		for _, sub := range subs {
+			if sub.IsDir() {
+				names = append(names, entry.Name()+"/"+sub.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// PlanPackage 计算将补丁包中的 srcPkgPath 合并到 targetPkgPath 后的结果，
+// 与本地已有版本冲突的版本按 opts.ConflictPolicy 处理。两者包名不同时按重命名处理
+func PlanPackage(srcPkgPath, targetPkgPath string, opts PatchOptions) (*PackagePlan, error) {
+	plan := &PackagePlan{
+		Name:       packageName(targetPkgPath),
+		SrcPath:    srcPkgPath,
+		TargetPath: targetPkgPath,
+		Files:      make([]string, 0),
+		srcFiles:   make(map[string]string),
+		Conflicts:  make([]VersionConflict, 0),
+		Options:    opts,
+	}
+
+	src, err := GetPackage(srcPkgPath)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "读取 package.json 失败：%s", srcPkgPath)
+	}
+	src.initMaps()
+	srcName := packageName(srcPkgPath)
+	if srcName != plan.Name {
+		plan.RenamedFrom = srcName
+		renamePackage(src, srcName, plan.Name)
+	}
+	plan.Aliases = opts.Renamer.aliasDependencies(src)
+	plan.Src = src
+
+	// 读取依赖包目录，获取所有目标目录中不存在的版本文件
+	entries, err := os.ReadDir(srcPkgPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取目录失败：%s", srcPkgPath)
+	}
+	plan.MetadataOnly = true
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == "package.json" {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), ".tgz") {
+			plan.MetadataOnly = false
+		}
+		filename := renameFile(srcName, plan.Name, entry.Name())
+		if filename != entry.Name() {
+			plan.srcFiles[filename] = entry.Name()
+		}
+		if utils.PathExists(filepath.Join(targetPkgPath, filename)) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			plan.Bytes += info.Size()
+		}
+		plan.Files = append(plan.Files, filename)
+	}
+
+	var dists []string
+	if utils.PathExists(targetPkgPath) {
+		if plan.Before, err = GetPackage(targetPkgPath); err != nil {
+			return nil, errors.WithMessagef(err, "读取 package.json 失败：%s", targetPkgPath)
+		}
+		if dists, err = GetLocalDistFiles(targetPkgPath); err != nil {
+			return nil, errors.WithMessagef(err, "获取获取本地依赖包发布版失败：%s", targetPkgPath)
+		}
+		if plan.MetadataOnly {
+			// 只有 package.json 的补丁包只同步元数据，不会新增版本，也不需要检查冲突
+			plan.After = mergeMetadata(plan.Before, src)
+		} else {
+			// 合并package.json
+			if plan.After, err = mergePackageJson(src, targetPkgPath); err != nil {
+				return nil, errors.WithMessagef(err, "合并 package.json失败: [%s -> %s]", srcPkgPath, targetPkgPath)
+			}
+			if plan.Conflicts, err = detectConflicts(plan.Name, targetPkgPath, src, plan.Before, plan.srcFile, opts.WorkerOptions); err != nil {
+				return nil, errors.WithMessagef(err, "检查版本冲突失败：%s", plan.Name)
+			}
+			if len(plan.Conflicts) > 0 && opts.ConflictPolicy != ConflictFail {
+				rejectConflicts(plan.After, plan.Before, plan.Conflicts)
+			}
+		}
+	} else {
+		plan.After = src.clone()
+	}
+
+	// 排除准入策略拒绝的版本
+	plan.Rejected = plan.checkPolicy()
+	for _, v := range plan.Rejected {
+		plan.exclude(v.Version, v.Filename)
+	}
+
+	// 排除校验和不一致的 tarball 及其版本
+	if plan.IntegrityErrors, err = verifyTarballs(plan.Name, src, plan.Files, plan.srcFile, opts.WorkerOptions); err != nil {
+		return nil, errors.WithMessagef(err, "校验 tarball 失败：%s", plan.Name)
+	}
+	for _, e := range plan.IntegrityErrors {
+		plan.exclude(e.Version, e.Filename)
+	}
+
+	for _, file := range plan.Files {
+		if strings.HasSuffix(file, ".tgz") {
+			dists = append(dists, file)
+		}
+	}
+
+	// 整理package.json
+	plan.Upstream = adjustPackage(plan.After, dists)
+	if plan.MetadataOnly {
+		// 只有 package.json 的补丁包中本地不存在的版本不会合并，同样记录为上游版本
+		mergeUpstream(plan.Upstream, collectUpstream(src, lo.Keys(plan.After.Versions)))
+	}
+	// 清理上游元数据
+	plan.Normalized = opts.Normalizer.Normalize(plan.After)
+	// 替换 registry 地址
+	plan.Rewrites = opts.Rewriter.Rewrite(plan.After)
+	// 受保护的包撤销所有修改
+	plan.Protected = plan.checkProtection()
+	return plan, nil
+}
+
+// srcFile 获取文件 file（重命名后的文件名）在补丁包中的路径
+func (p *PackagePlan) srcFile(file string) string {
+	if name, ok := p.srcFiles[file]; ok {
+		return filepath.Join(p.SrcPath, name)
+	}
+	return filepath.Join(p.SrcPath, file)
+}
+
+// exclude 从合并结果中排除版本 version 及其文件 filename，filename 不会被复制
+func (p *PackagePlan) exclude(version, filename string) {
+	if i := lo.IndexOf(p.Files, filename); i >= 0 {
+		if info, err := os.Stat(p.srcFile(filename)); err == nil {
+			p.Bytes -= info.Size()
+		}
+		p.Files = append(p.Files[:i:i], p.Files[i+1:]...)
+	}
+	restoreVersion(p.After, p.Before, version, filename)
+}
+
+// Apply 复制新增的文件并写入合并后的 package.json，ConflictFail 策略下存在冲突时不写入任何文件。
+// 受保护的包、本地不存在且所有版本都被排除的包不会写入 storage。修改前会先在快照中记录包的原有状态，文件均先写入临时文件再重命名
+func (p *PackagePlan) Apply() error {
+	if p.Protected != nil {
+		if p.Protected.Action == ProtectQuarantine {
+			return quarantineProtected(p.Options.QuarantineDir, p)
+		}
+		return nil
+	}
+	if p.Before == nil && len(p.After.Versions) == 0 {
+		// 本地不存在且没有可合并的版本时只记录上游版本
+		if p.Upstream != nil && p.Options.Snapshot != nil {
+			if err := p.Options.Snapshot.Record(p.Name, p.TargetPath, nil); err != nil {
+				return err
+			}
+		}
+		return upstream.Merge(p.Upstream, nil)
+	}
+	if len(p.Conflicts) > 0 {
+		switch p.Options.ConflictPolicy {
+		case ConflictFail:
+			versions := make([]string, 0, len(p.Conflicts))
+			for _, c := range p.Conflicts {
+				versions = append(versions, c.Version)
+			}
+			return errors.Errorf("%s 存在版本冲突：%s", p.Name, strings.Join(versions, "、"))
+		case ConflictQuarantine:
+			if err := quarantine(p.Options.QuarantineDir, p); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Options.Snapshot != nil {
+		if err := p.Options.Snapshot.Record(p.Name, p.TargetPath, p.Files); err != nil {
+			return err
+		}
+	}
+	for _, file := range p.Files {
+		// 如果不存在就复制到目标目录
+		if err := p.Options.copyFile(p.srcFile(file), filepath.Join(p.TargetPath, file)); err != nil {
+			return errors.Wrapf(err, "复制 %s 失败", file)
+		}
+	}
+	if err := savePackage(p.TargetPath, p.After); err != nil {
+		return errors.WithMessagef(err, "整理 package.json 失败：%s", filepath.Join(p.TargetPath, "package.json"))
+	}
+	if err := upstream.Merge(p.Upstream, lo.Keys(p.After.Versions)); err != nil {
+		return errors.WithMessagef(err, "记录上游版本失败：%s", p.Name)
+	}
+	return nil
+}
+
+// Change 汇总 plan 中的变更明细
+func (p *PackagePlan) Change() PackageChange {
+	change := PackageChange{
+		Name:             p.Name,
+		RenamedFrom:      p.RenamedFrom,
+		New:              p.Before == nil,
+		MetadataOnly:     p.MetadataOnly,
+		NewVersions:      make([]string, 0),
+		ExistingVersions: make([]string, 0),
+		DistTags:         make([]DistTagChange, 0),
+		ChangedFields:    make([]string, 0),
+		Files:            p.Files,
+		Bytes:            p.Bytes,
+		Conflicts:        p.Conflicts,
+		IntegrityErrors:  p.IntegrityErrors,
+		Rejected:         p.Rejected,
+		Rewrites:         p.Rewrites,
+		Aliases:          p.Aliases,
+		Normalized:       p.Normalized,
+		Protected:        p.Protected,
+	}
+
+	before := p.Before
+	if before == nil {
+		before = &Package{}
+	}
+	for v := range p.After.Versions {
+		if _, ok := before.Versions[v]; !ok {
+			change.NewVersions = append(change.NewVersions, v)
+		}
+	}
+	for v := range p.Src.Versions {
+		if _, ok := before.Versions[v]; ok {
+			change.ExistingVersions = append(change.ExistingVersions, v)
+		}
+	}
+	sortVersions(change.NewVersions)
+	sortVersions(change.ExistingVersions)
+
+	change.DistTags = diffDistTags(before.DistTags, p.After.DistTags)
+
+	if p.Before != nil {
+		change.ChangedFields = diffFields(p.Before, p.After)
+	}
+	return change
+}
+
+// diffDistTags 比较 dist-tags 的变化
+func diffDistTags(before, after map[string]string) []DistTagChange {
+	changes := make([]DistTagChange, 0)
+	for tag, to := range after {
+		if from := before[tag]; from != to {
+			changes = append(changes, DistTagChange{Tag: tag, From: from, To: to})
+		}
+	}
+	for tag, from := range before {
+		if _, ok := after[tag]; !ok {
+			changes = append(changes, DistTagChange{Tag: tag, From: from})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Tag < changes[j].Tag
+	})
+	return changes
+}
+
+// diffFields 比较两个 package.json 中发生变化的顶层字段
+func diffFields(before, after *Package) []string {
+	a, b := topLevelFields(before), topLevelFields(after)
+	fields := make([]string, 0)
+	for k, v := range b {
+		if !bytes.Equal(a[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func topLevelFields(pkg *Package) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	content, err := json.Marshal(pkg)
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(content, &fields)
+	return fields
+}
+
+// sortVersions 按语义化版本升序排序，无法解析的版本按字符串排在最后
+func sortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		a, e1 := semver.NewVersion(versions[i])
+		b, e2 := semver.NewVersion(versions[j])
+		switch {
+		case e1 == nil && e2 == nil:
+			return a.LessThan(b)
+		case e1 == nil:
+			return true
+		case e2 == nil:
+			return false
+		}
+		return versions[i] < versions[j]
+	})
+}
+
+// PreviewStorage 预览将补丁目录 src 合并到 storage 的结果，不会写入任何文件
+func PreviewStorage(src string, opts PatchOptions) (*PatchReport, error) {
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+	names, err := ListPackageDirs(src)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := opts.Renamer.Targets(names)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &PatchReport{
+		Packages:  make([]PackageChange, 0, len(names)),
+		CreatedAt: time.Now(),
+	}
+	for i, name := range names {
+		plan, err := PlanPackage(filepath.Join(src, name), filepath.Join(storagePath, targets[i]), opts)
+		if err != nil {
+			report.Packages = append(report.Packages, PackageChange{Name: targets[i], Error: err.Error()})
+			continue
+		}
+		change := plan.Change()
+		report.Packages = append(report.Packages, change)
+		report.TotalBytes += change.Bytes
+	}
+	return report, nil
+}
@@ -0,0 +1,233 @@
+package verdaccio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"verda/utils"
+
+	"github.com/samber/lo"
+)
+
+// Policy 补丁包准入策略，对补丁包中每个新增的版本逐条检查，任一规则不通过的版本不会合并
+type Policy struct {
+	// 允许的包名（支持 * 和 ? 通配符，如 @corp/*、lodash*），为空时允许所有包
+	Allow []string `json:"allow"`
+	// 禁止的包名，优先于 Allow
+	Deny []string `json:"deny"`
+	// 允许的 license，为空时不检查
+	Licenses []string `json:"licenses"`
+	// tarball 大小上限（字节），0 表示不限制
+	MaxTarballSize int64 `json:"maxTarballSize"`
+	// 禁止包含 preinstall、install、postinstall 脚本的版本
+	DenyInstallScripts bool `json:"denyInstallScripts"`
+	// 禁止已废弃（deprecated）的版本
+	DenyDeprecated bool `json:"denyDeprecated"`
+}
+
+// PolicyViolation 被准入策略拒绝的版本
+type PolicyViolation struct {
+	Version  string `json:"version"`
+	Filename string `json:"filename"`
+	// 拒绝该版本的规则：deny、allow、license、maxTarballSize、installScripts、deprecated
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// installScripts npm 安装时自动执行的脚本
+var installScripts = []string{"preinstall", "install", "postinstall"}
+
+// Evaluate 检查包 name 的版本 version，manifest 为版本元数据，size 为 tarball 大小（未知时为 -1），
+// 通过时返回 nil
+func (p *Policy) Evaluate(name, version string, manifest any, size int64) *PolicyViolation {
+	if p == nil {
+		return nil
+	}
+	reject := func(rule, reason string) *PolicyViolation {
+		return &PolicyViolation{Version: version, Filename: DistFilename(name, version), Rule: rule, Reason: reason}
+	}
+
+	if pattern, ok := lo.Find(p.Deny, func(pattern string) bool { return matchGlob(pattern, name) }); ok {
+		return reject("deny", "包名匹配禁止规则 "+pattern)
+	}
+	if len(p.Allow) > 0 && !lo.SomeBy(p.Allow, func(pattern string) bool { return matchGlob(pattern, name) }) {
+		return reject("allow", "包名不在允许列表中")
+	}
+
+	m, _ := manifest.(map[string]any)
+	if len(p.Licenses) > 0 {
+		license := manifestLicense(m)
+		if license == "" {
+			return reject("license", "缺少 license")
+		}
+		if !p.allowLicense(license) {
+			return reject("license", "license 不在允许列表中："+license)
+		}
+	}
+	if p.MaxTarballSize > 0 && size > p.MaxTarballSize {
+		return reject("maxTarballSize", fmt.Sprintf("tarball 大小 %d 超过上限 %d", size, p.MaxTarballSize))
+	}
+	if p.DenyInstallScripts {
+		if scripts, ok := m["scripts"].(map[string]any); ok {
+			for _, script := range installScripts {
+				if _, ok := scripts[script]; ok {
+					return reject("installScripts", "包含 "+script+" 脚本")
+				}
+			}
+		}
+		if has, _ := m["hasInstallScript"].(bool); has {
+			return reject("installScripts", "包含安装脚本")
+		}
+	}
+	if p.DenyDeprecated {
+		if deprecated, _ := m["deprecated"].(string); deprecated != "" {
+			return reject("deprecated", "已废弃："+deprecated)
+		}
+	}
+	return nil
+}
+
+// allowLicense 检查 SPDX 表达式：AND 连接时每一项都需要允许，OR 连接时任一项允许即可
+func (p *Policy) allowLicense(expression string) bool {
+	allowed := func(license string) bool {
+		license = strings.Trim(strings.TrimSpace(license), "()")
+		return lo.SomeBy(p.Licenses, func(l string) bool { return strings.EqualFold(l, license) })
+	}
+	expression = strings.Trim(strings.TrimSpace(expression), "()")
+	if parts := strings.Split(expression, " AND "); len(parts) > 1 {
+		return lo.EveryBy(parts, allowed)
+	}
+	return lo.SomeBy(strings.Split(expression, " OR "), allowed)
+}
+
+// manifestLicense 获取版本元数据中的 license，兼容 {type} 对象及旧版的 licenses 数组
+func manifestLicense(m map[string]any) string {
+	switch l := m["license"].(type) {
+	case string:
+		return l
+	case map[string]any:
+		if t, ok := l["type"].(string); ok {
+			return t
+		}
+	}
+	if list, ok := m["licenses"].([]any); ok {
+		types := make([]string, 0, len(list))
+		for _, item := range list {
+			if l, ok := item.(map[string]any); ok {
+				if t, ok := l["type"].(string); ok {
+					types = append(types, t)
+				}
+			}
+		}
+		return strings.Join(types, " OR ")
+	}
+	return ""
+}
+
+// matchGlob 匹配包名，* 匹配任意字符（包括 /），? 匹配单个字符
+func matchGlob(pattern, name string) bool {
+	p, n := 0, 0
+	star, mark := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, n
+			p++
+		case star >= 0:
+			p, mark = star+1, mark+1
+			n = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// checkPolicy 检查补丁包中本地不存在的版本，返回被拒绝的版本
+func (p *PackagePlan) checkPolicy() []PolicyViolation {
+	violations := make([]PolicyViolation, 0)
+	if p.Options.Policy == nil {
+		return violations
+	}
+	for version, manifest := range p.Src.Versions {
+		if p.Before != nil {
+			if _, ok := p.Before.Versions[version]; ok {
+				continue
+			}
+		}
+		size := int64(-1)
+		if info, err := os.Stat(p.srcFile(DistFilename(p.Name, version))); err == nil {
+			size = info.Size()
+		}
+		if v := p.Options.Policy.Evaluate(p.Name, version, manifest, size); v != nil {
+			violations = append(violations, *v)
+		}
+	}
+	sortViolations(violations)
+	return violations
+}
+
+func sortViolations(violations []PolicyViolation) {
+	versions := make([]string, 0, len(violations))
+	byVersion := make(map[string]PolicyViolation, len(violations))
+	for _, v := range violations {
+		versions = append(versions, v.Version)
+		byVersion[v.Version] = v
+	}
+	sortVersions(versions)
+	for i, v := range versions {
+		violations[i] = byVersion[v]
+	}
+}
+
+// PackagePolicy 单个包的准入检查结果
+type PackagePolicy struct {
+	Name     string            `json:"name"`
+	Allowed  []string          `json:"allowed"`
+	Rejected []PolicyViolation `json:"rejected"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// CheckPolicy 按准入策略检查补丁目录 src 中的所有版本（不论本地是否已存在），不会写入任何文件
+func CheckPolicy(src string, policy *Policy) ([]PackagePolicy, error) {
+	names, err := ListPackageDirs(src)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]PackagePolicy, 0, len(names))
+	for _, name := range names {
+		pkgPath := filepath.Join(src, name)
+		item := PackagePolicy{Name: name, Allowed: make([]string, 0), Rejected: make([]PolicyViolation, 0)}
+		if !utils.PathExists(filepath.Join(pkgPath, "package.json")) {
+			continue
+		}
+		pkg, err := GetPackage(pkgPath)
+		if err != nil {
+			item.Error = err.Error()
+			result = append(result, item)
+			continue
+		}
+		for version, manifest := range pkg.Versions {
+			size := int64(-1)
+			if info, err := os.Stat(filepath.Join(pkgPath, DistFilename(name, version))); err == nil {
+				size = info.Size()
+			}
+			if v := policy.Evaluate(name, version, manifest, size); v != nil {
+				item.Rejected = append(item.Rejected, *v)
+			} else {
+				item.Allowed = append(item.Allowed, version)
+			}
+		}
+		sortVersions(item.Allowed)
+		sortViolations(item.Rejected)
+		result = append(result, item)
+	}
+	return result, nil
+}
@@ -0,0 +1,177 @@
+package verdaccio
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"lodash", "lodash", true},
+		{"lodash", "lodash-es", false},
+		{"lodash*", "lodash-es", true},
+		{"lodash*", "lodash", true},
+		{"@corp/*", "@corp/ui", true},
+		{"@corp/*", "@corp-ui/button", false},
+		{"@corp*", "@corp-ui/button", true},
+		{"*", "@corp/ui", true},
+		{"@*/core", "@vue/core", true},
+		{"@*/core", "@vue/core-js", false},
+		{"?ue", "vue", true},
+		{"?ue", "ue", false},
+		{"*-plugin-*", "babel-plugin-foo", true},
+		{"*-plugin-*", "babel-preset-foo", false},
+		{"", "", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *Policy
+		pkg      string
+		manifest map[string]any
+		size     int64
+		// 拒绝的规则，为空表示通过
+		want string
+	}{
+		{name: "未配置策略", policy: nil, pkg: "lodash", want: ""},
+		{name: "禁止规则", policy: &Policy{Deny: []string{"evil-*"}}, pkg: "evil-pkg", want: "deny"},
+		{name: "禁止规则优先于允许规则", policy: &Policy{Allow: []string{"*"}, Deny: []string{"@bad/*"}}, pkg: "@bad/x", want: "deny"},
+		{name: "不在允许列表中", policy: &Policy{Allow: []string{"@corp/*"}}, pkg: "lodash", want: "allow"},
+		{name: "在允许列表中", policy: &Policy{Allow: []string{"@corp/*", "lodash*"}}, pkg: "lodash-es", want: ""},
+		{
+			name:     "license 允许",
+			policy:   &Policy{Licenses: []string{"MIT"}},
+			pkg:      "lodash",
+			manifest: map[string]any{"license": "mit"},
+		},
+		{
+			name:     "缺少 license",
+			policy:   &Policy{Licenses: []string{"MIT"}},
+			pkg:      "lodash",
+			manifest: map[string]any{},
+			want:     "license",
+		},
+		{
+			name:     "license OR 表达式任一允许",
+			policy:   &Policy{Licenses: []string{"MIT"}},
+			pkg:      "lodash",
+			manifest: map[string]any{"license": "(GPL-3.0 OR MIT)"},
+		},
+		{
+			name:     "license AND 表达式需要全部允许",
+			policy:   &Policy{Licenses: []string{"MIT"}},
+			pkg:      "lodash",
+			manifest: map[string]any{"license": "MIT AND GPL-3.0"},
+			want:     "license",
+		},
+		{
+			name:     "旧版 licenses 数组",
+			policy:   &Policy{Licenses: []string{"Apache-2.0"}},
+			pkg:      "lodash",
+			manifest: map[string]any{"licenses": []any{map[string]any{"type": "MIT"}, map[string]any{"type": "Apache-2.0"}}},
+		},
+		{name: "tarball 超过上限", policy: &Policy{MaxTarballSize: 10}, pkg: "lodash", size: 11, want: "maxTarballSize"},
+		{name: "tarball 大小未知", policy: &Policy{MaxTarballSize: 10}, pkg: "lodash", size: -1},
+		{
+			name:     "安装脚本",
+			policy:   &Policy{DenyInstallScripts: true},
+			pkg:      "lodash",
+			manifest: map[string]any{"scripts": map[string]any{"test": "jest", "postinstall": "node x.js"}},
+			want:     "installScripts",
+		},
+		{
+			name:     "hasInstallScript",
+			policy:   &Policy{DenyInstallScripts: true},
+			pkg:      "lodash",
+			manifest: map[string]any{"hasInstallScript": true},
+			want:     "installScripts",
+		},
+		{
+			name:     "已废弃",
+			policy:   &Policy{DenyDeprecated: true},
+			pkg:      "lodash",
+			manifest: map[string]any{"deprecated": "use lodash-es"},
+			want:     "deprecated",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.policy.Evaluate(tt.pkg, "1.0.0", tt.manifest, tt.size)
+			got := ""
+			if v != nil {
+				got = v.Rule
+				if v.Version != "1.0.0" || v.Filename != DistFilename(tt.pkg, "1.0.0") {
+					t.Errorf("violation = %+v", v)
+				}
+			}
+			if got != tt.want {
+				t.Errorf("rule = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanPackagePolicy(t *testing.T) {
+	root := t.TempDir()
+	srcPath, targetPath := filepath.Join(root, "patch", "demo"), filepath.Join(root, "storage", "demo")
+	versions := map[string]any{
+		"1.0.0":  testManifest("demo", "1.0.0", map[string]any{"deprecated": "old"}),
+		"1.2.0":  testManifest("demo", "1.2.0", map[string]any{"deprecated": "old"}),
+		"1.10.0": testManifest("demo", "1.10.0", map[string]any{"deprecated": "old"}),
+		"1.11.0": testManifest("demo", "1.11.0", nil),
+	}
+	writePackageDir(t, targetPath, &Package{
+		Name:     "demo",
+		Versions: map[string]any{"1.0.0": versions["1.0.0"]},
+		DistTags: map[string]string{"latest": "1.0.0"},
+	}, map[string]string{"demo-1.0.0.tgz": "1.0.0"})
+	writePackageDir(t, srcPath, &Package{
+		Name:     "demo",
+		Versions: versions,
+		DistTags: map[string]string{"latest": "1.11.0"},
+	}, map[string]string{"demo-1.0.0.tgz": "1.0.0", "demo-1.2.0.tgz": "1.2.0", "demo-1.10.0.tgz": "1.10.0", "demo-1.11.0.tgz": "1.11.0"})
+
+	plan, err := PlanPackage(srcPath, targetPath, PatchOptions{Policy: &Policy{DenyDeprecated: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 本地已存在的 1.0.0 不检查，被拒绝的版本按语义化版本排序
+	var rejected []string
+	for _, v := range plan.Rejected {
+		rejected = append(rejected, v.Version)
+	}
+	if want := []string{"1.2.0", "1.10.0"}; !reflect.DeepEqual(rejected, want) {
+		t.Errorf("rejected = %v, want %v", rejected, want)
+	}
+	if !reflect.DeepEqual(plan.Files, []string{"demo-1.11.0.tgz"}) {
+		t.Errorf("files = %v", plan.Files)
+	}
+	for _, v := range []string{"1.2.0", "1.10.0"} {
+		if _, ok := plan.After.Versions[v]; ok {
+			t.Errorf("被拒绝的版本 %s 不应合并", v)
+		}
+	}
+	if latest := plan.After.DistTags["latest"]; latest != "1.11.0" {
+		t.Errorf("latest = %q, want 1.11.0", latest)
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := []string{"1.10.0", "invalid", "1.2.0", "1.2.0-beta.1", "0.9.0"}
+	sortVersions(versions)
+	if want := []string{"0.9.0", "1.2.0-beta.1", "1.2.0", "1.10.0", "invalid"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("sortVersions() = %v, want %v", versions, want)
+	}
+}
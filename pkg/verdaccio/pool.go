@@ -0,0 +1,71 @@
+package verdaccio
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"sync"
+	"verda/utils"
+)
+
+// WorkerOptions 批量处理 storage 中的包时的并发与 IO 限制
+type WorkerOptions struct {
+	// 同时处理的包数量，小于 1 时按 1 处理
+	Concurrency int
+	// 限制读取 tarball（复制、计算校验和）的速度，为 nil 时不限速，避免与同一磁盘上的 verdaccio 争抢 IO
+	Limiter *utils.RateLimiter
+	// 开始处理前回调一次需要处理的包总数，为 nil 时忽略
+	OnStart func(total int64)
+}
+
+// runPool 使用 opts.Concurrency 个 worker 依次处理 0 到 n-1，全部处理完后返回。
+// ctx 取消后仍会对剩余的任务调用 fn，由 fn 检查 ctx 并快速返回，保证每个任务都有结果
+func runPool(ctx context.Context, opts WorkerOptions, n int, fn func(i int)) {
+	if opts.OnStart != nil {
+		opts.OnStart(int64(n))
+	}
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	tasks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range tasks {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+}
+
+// checksums 计算文件的 sha1 和 sha512，读取速度受 Limiter 限制
+func (o WorkerOptions) checksums(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	return utils.Checksums(o.Limiter.Reader(bufio.NewReader(file)))
+}
+
+// copyFile 以临时文件加重命名的方式复制文件，读取速度受 Limiter 限制
+func (o WorkerOptions) copyFile(from, to string) error {
+	file, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return utils.WriteAtomic(o.Limiter.Reader(bufio.NewReader(file)), to)
+}
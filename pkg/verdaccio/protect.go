@@ -0,0 +1,190 @@
+package verdaccio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"verda/utils"
+
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+)
+
+// DBFile verdaccio 记录本地发布的包的文件，位于 storage 目录下
+const DBFile = ".verdaccio-db.json"
+
+// ProtectAction 补丁包试图修改受保护的包时的处理方式
+type ProtectAction string
+
+const (
+	// ProtectBlock 不合并补丁包中该包的任何内容
+	ProtectBlock ProtectAction = "block"
+	// ProtectQuarantine 与 block 相同，同时将补丁包中该包的内容隔离保存
+	ProtectQuarantine ProtectAction = "quarantine"
+)
+
+// ParseProtectAction 解析受保护包的处理方式
+func ParseProtectAction(s string) (ProtectAction, error) {
+	switch a := ProtectAction(s); a {
+	case ProtectBlock, ProtectQuarantine:
+		return a, nil
+	}
+	return "", errors.Errorf("未知的受保护包处理方式 %q，可选值：block、quarantine", s)
+}
+
+// Protection 受保护的包：内网发布的包（.verdaccio-db.json）及配置的包名，补丁包不允许为其新增版本或移动 dist-tags，
+// 避免公网的同名包混入（依赖混淆）
+type Protection struct {
+	// 内网发布的包名
+	Published []string
+	// 受保护的包名，支持 * 和 ? 通配符
+	Patterns []string
+	Action   ProtectAction
+}
+
+// ProtectionViolation 补丁包试图修改受保护的包
+type ProtectionViolation struct {
+	// 受保护的原因：published-内网发布的包，pattern-匹配配置的包名
+	Reason  string `json:"reason"`
+	Pattern string `json:"pattern,omitempty"`
+	// 补丁包试图新增的版本及移动的 dist-tags
+	Versions []string        `json:"versions"`
+	DistTags []DistTagChange `json:"distTags"`
+	Action   ProtectAction   `json:"action"`
+}
+
+// Message 违规信息的描述
+func (v *ProtectionViolation) Message(name string) string {
+	changes := make([]string, 0, 2)
+	if len(v.Versions) > 0 {
+		changes = append(changes, "新增版本 "+strings.Join(v.Versions, "、"))
+	}
+	if len(v.DistTags) > 0 {
+		tags := make([]string, 0, len(v.DistTags))
+		for _, t := range v.DistTags {
+			tags = append(tags, t.Tag)
+		}
+		changes = append(changes, "移动 dist-tags "+strings.Join(tags, "、"))
+	}
+	reason := "内网发布的包"
+	if v.Reason == "pattern" {
+		reason = "匹配受保护的包名 " + v.Pattern
+	}
+	return fmt.Sprintf("%s 为%s，已阻止补丁包%s", name, reason, strings.Join(changes, "，"))
+}
+
+type verdaccioDB struct {
+	List []string `json:"list"`
+}
+
+// LoadProtection 读取 storage 目录下的 .verdaccio-db.json，与 patterns 一起生成受保护的包，文件不存在时只使用 patterns
+func LoadProtection(patterns []string, action ProtectAction) (*Protection, error) {
+	p := &Protection{Published: make([]string, 0), Patterns: patterns, Action: action}
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+	path := filepath.Join(storagePath, DBFile)
+	if !utils.PathExists(path) {
+		return p, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法读取 %s", path)
+	}
+	db := &verdaccioDB{}
+	if err = json.Unmarshal(content, db); err != nil {
+		return nil, errors.Wrapf(err, "无法解析 %s", path)
+	}
+	p.Published = db.List
+	return p, nil
+}
+
+// match 检查包是否受保护，返回原因及匹配的包名规则
+func (p *Protection) match(name string) (string, string, bool) {
+	if p == nil {
+		return "", "", false
+	}
+	if lo.Contains(p.Published, name) {
+		return "published", "", true
+	}
+	if pattern, ok := lo.Find(p.Patterns, func(pattern string) bool { return matchGlob(pattern, name) }); ok {
+		return "pattern", pattern, true
+	}
+	return "", "", false
+}
+
+// checkProtection 包受保护且合并结果会新增版本或移动 dist-tags 时，撤销对该包的所有修改并返回违规信息
+func (p *PackagePlan) checkProtection() *ProtectionViolation {
+	reason, pattern, ok := p.Options.Protection.match(p.Name)
+	if !ok {
+		return nil
+	}
+	before := p.Before
+	if before == nil {
+		before = &Package{}
+	}
+	versions := make([]string, 0)
+	for v := range p.After.Versions {
+		if _, ok := before.Versions[v]; !ok {
+			versions = append(versions, v)
+		}
+	}
+	sortVersions(versions)
+	tags := diffDistTags(before.DistTags, p.After.DistTags)
+	if len(versions) == 0 && len(tags) == 0 {
+		return nil
+	}
+
+	// 撤销修改：不复制任何文件，合并结果与原有的 package.json 相同
+	p.Files, p.Bytes = make([]string, 0), 0
+	p.Rewrites, p.Normalized = make([]RewriteChange, 0), make([]string, 0)
+	if p.Before != nil {
+		p.After = p.Before.clone()
+	} else {
+		p.After = &Package{Name: p.Name}
+		p.After.initMaps()
+	}
+	return &ProtectionViolation{Reason: reason, Pattern: pattern, Versions: versions, DistTags: tags, Action: p.Options.Protection.Action}
+}
+
+// clone 深拷贝 package.json
+func (p *Package) clone() *Package {
+	content, _ := json.Marshal(p)
+	c := &Package{}
+	_ = json.Unmarshal(content, c)
+	c.initMaps()
+	return c
+}
+
+// quarantineProtected 将补丁包中受保护的包的 package.json 及 tarball 保存到 dir/<包名>/protected-<时间戳> 目录
+func quarantineProtected(dir string, plan *PackagePlan) error {
+	target := filepath.Join(dir, plan.Name, "protected-"+strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if err := os.MkdirAll(target, os.ModePerm); err != nil {
+		return errors.Wrapf(err, "无法创建隔离目录：%s", target)
+	}
+	entries, err := os.ReadDir(plan.SrcPath)
+	if err != nil {
+		return errors.Wrapf(err, "读取目录失败：%s", plan.SrcPath)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err = plan.Options.copyFile(filepath.Join(plan.SrcPath, entry.Name()), filepath.Join(target, entry.Name())); err != nil {
+			return errors.Wrapf(err, "隔离 %s 失败", entry.Name())
+		}
+	}
+	content, err := json.MarshalIndent(plan.Protected, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "序列化受保护包的违规信息失败")
+	}
+	if err = os.WriteFile(filepath.Join(target, "protected.json"), content, 0644); err != nil {
+		return errors.Wrapf(err, "隔离 %s 失败", plan.Name)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package verdaccio
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Renamer 导入时重命名包：补丁包中的包合并到新的包名下，同时修改 package.json 中的包名、tarball 文件名及地址。
+// tarball 内部的 package.json 不会修改，npm 安装时以 registry 返回的元数据为准
+type Renamer struct {
+	// 包名映射，key 为补丁包中的包名，如 foo -> @corp/foo
+	Packages map[string]string
+	// scope 映射，key 为补丁包中的 @scope，如 @vue -> @corp-vue
+	Scopes map[string]string
+	// 为 true 时将补丁包中对被重命名的包的依赖改为别名（npm:新包名@版本范围），代码中的 require 路径不受影响
+	Dependencies bool
+}
+
+// aliasFields 需要改为别名的依赖字段，只处理安装时会用到的字段
+var aliasFields = []string{"dependencies", "optionalDependencies", "peerDependencies"}
+
+// ValidateRename 检查包名及 scope 映射的格式
+func ValidateRename(packages, scopes map[string]string) error {
+	for from, to := range packages {
+		if !validPackageName(from) || !validPackageName(to) {
+			return errors.Errorf("无效的包名映射：%s -> %s", from, to)
+		}
+	}
+	for from, to := range scopes {
+		if !validScope(from) || !validScope(to) {
+			return errors.Errorf("无效的 scope 映射：%s -> %s，scope 必须以 @ 开头且不包含 /", from, to)
+		}
+	}
+	return nil
+}
+
+func validScope(scope string) bool {
+	return len(scope) > 1 && strings.HasPrefix(scope, "@") && !strings.ContainsAny(scope, `/\ `) && !strings.Contains(scope, "..")
+}
+
+// Target 获取包 name 重命名后的包名，包名映射优先于 scope 映射，没有匹配的规则时返回 name
+func (r *Renamer) Target(name string) string {
+	if r == nil {
+		return name
+	}
+	if to, ok := r.Packages[name]; ok {
+		return to
+	}
+	if scope, rest, ok := strings.Cut(name, "/"); ok {
+		if to, ok := r.Scopes[scope]; ok {
+			return to + "/" + rest
+		}
+	}
+	return name
+}
+
+// Targets 计算补丁目录中每个包重命名后的包名，多个包重命名后为同一个包名时返回错误
+func (r *Renamer) Targets(names []string) ([]string, error) {
+	targets := make([]string, len(names))
+	owners := make(map[string]string, len(names))
+	for i, name := range names {
+		targets[i] = r.Target(name)
+		if owner, ok := owners[targets[i]]; ok {
+			return nil, errors.Errorf("补丁包中的 %s 与 %s 重命名后均为 %s", owner, name, targets[i])
+		}
+		owners[targets[i]] = name
+	}
+	return targets, nil
+}
+
+// renameFile 获取重命名后的 tarball 文件名，不是 from 的 tarball 时原样返回
+func renameFile(from, to, filename string) string {
+	prefix := path.Base(from) + "-"
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".tgz") {
+		return filename
+	}
+	return path.Base(to) + "-" + strings.TrimPrefix(filename, prefix)
+}
+
+// renameURL 将 tarball 地址中的 <from>/-/<文件名> 改为 <to>/-/<新文件名>，不符合该格式的地址原样返回
+func renameURL(from, to, url string) string {
+	base, filename := path.Split(url)
+	if !strings.HasSuffix(base, "/"+from+"/-/") {
+		return url
+	}
+	return strings.TrimSuffix(base, from+"/-/") + to + "/-/" + renameFile(from, to, filename)
+}
+
+// renamePackage 将 pkg 的包名从 from 改为 to，修改 name、_id、各版本的 name、_id、dist.tarball，
+// 以及 _distfiles、_attachments 中的文件名和地址
+func renamePackage(pkg *Package, from, to string) {
+	pkg.Name = to
+	if pkg.Id != "" {
+		pkg.Id = to
+	}
+	for version, manifest := range pkg.Versions {
+		m, ok := manifest.(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := m["name"]; ok {
+			m["name"] = to
+		}
+		if _, ok := m["_id"]; ok {
+			m["_id"] = to + "@" + version
+		}
+		if dist, ok := m["dist"].(map[string]any); ok {
+			if tarball, ok := dist["tarball"].(string); ok {
+				dist["tarball"] = renameURL(from, to, tarball)
+			}
+		}
+	}
+
+	distFiles := make(map[string]DistFile, len(pkg.DistFiles))
+	for filename, distFile := range pkg.DistFiles {
+		distFile.Url = renameURL(from, to, distFile.Url)
+		distFiles[renameFile(from, to, filename)] = distFile
+	}
+	pkg.DistFiles = distFiles
+
+	attachments := make(map[string]Attachment, len(pkg.Attachments))
+	for filename, attachment := range pkg.Attachments {
+		attachments[renameFile(from, to, filename)] = attachment
+	}
+	pkg.Attachments = attachments
+}
+
+// aliasDependencies 将 pkg 各版本中对被重命名的包的依赖改为别名，如 "foo": "^1.0.0" 改为 "foo": "npm:@corp/foo@^1.0.0"，
+// 已是别名的依赖只替换别名指向的包名；git、文件、url 等非 registry 依赖不处理。返回修改明细
+func (r *Renamer) aliasDependencies(pkg *Package) []RewriteChange {
+	changes := make([]RewriteChange, 0)
+	if r == nil || !r.Dependencies {
+		return changes
+	}
+	for version, manifest := range pkg.Versions {
+		m, ok := manifest.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range aliasFields {
+			deps, ok := m[field].(map[string]any)
+			if !ok {
+				continue
+			}
+			for dep, value := range deps {
+				spec, ok := value.(string)
+				if !ok {
+					continue
+				}
+				if to, ok := r.alias(dep, spec); ok {
+					deps[dep] = to
+					changes = append(changes, RewriteChange{Field: "versions." + version + "." + field + "." + dep, From: spec, To: to})
+				}
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// alias 计算依赖 dep 的版本范围 spec 改为别名后的值，不需要修改时返回 false
+func (r *Renamer) alias(dep, spec string) (string, bool) {
+	if aliased, ok := strings.CutPrefix(spec, "npm:"); ok {
+		// npm:name@range，scope 包的名称以 @ 开头
+		name, rng := aliased, ""
+		if i := strings.LastIndex(aliased, "@"); i > 0 {
+			name, rng = aliased[:i], aliased[i:]
+		}
+		if target := r.Target(name); target != name {
+			return "npm:" + target + rng, true
+		}
+		return spec, false
+	}
+	if strings.ContainsAny(spec, ":/") {
+		return spec, false
+	}
+	target := r.Target(dep)
+	if target == dep {
+		return spec, false
+	}
+	if spec == "" {
+		spec = "*"
+	}
+	return "npm:" + target + "@" + spec, true
+}
@@ -0,0 +1,219 @@
+package verdaccio
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRenamerTarget(t *testing.T) {
+	r := &Renamer{
+		Packages: map[string]string{"@vue/shared": "vue-shared", "lodash": "@corp/lodash"},
+		Scopes:   map[string]string{"@vue": "@corp-vue"},
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"@vue/shared", "vue-shared"},
+		{"@vue/core", "@corp-vue/core"},
+		{"lodash", "@corp/lodash"},
+		{"lodash-es", "lodash-es"},
+		{"@babel/core", "@babel/core"},
+	}
+	for _, tt := range tests {
+		if got := r.Target(tt.name); got != tt.want {
+			t.Errorf("Target(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	var nilRenamer *Renamer
+	if got := nilRenamer.Target("@vue/core"); got != "@vue/core" {
+		t.Errorf("nil Renamer Target() = %q", got)
+	}
+}
+
+func TestRenamerTargetsDuplicate(t *testing.T) {
+	r := &Renamer{Packages: map[string]string{"@vue/shared": "shared"}}
+	if _, err := r.Targets([]string{"@vue/shared", "shared"}); err == nil {
+		t.Error("重命名后包名重复时应返回错误")
+	}
+}
+
+func TestRenameFileAndURL(t *testing.T) {
+	tests := []struct {
+		from, to          string
+		filename, url     string
+		wantFile, wantURL string
+	}{
+		{
+			from: "@vue/shared", to: "vue-shared",
+			filename: "shared-3.4.0.tgz", url: "https://registry.npmjs.org/@vue/shared/-/shared-3.4.0.tgz",
+			wantFile: "vue-shared-3.4.0.tgz", wantURL: "https://registry.npmjs.org/vue-shared/-/vue-shared-3.4.0.tgz",
+		},
+		{
+			from: "lodash", to: "@corp/lodash",
+			filename: "lodash-4.17.21.tgz", url: "http://npm.local/lodash/-/lodash-4.17.21.tgz",
+			wantFile: "lodash-4.17.21.tgz", wantURL: "http://npm.local/@corp/lodash/-/lodash-4.17.21.tgz",
+		},
+		{
+			from: "@vue/core", to: "@corp-vue/core",
+			filename: "core-3.4.0.tgz", url: "https://registry.npmjs.org/@vue/core/-/core-3.4.0.tgz",
+			wantFile: "core-3.4.0.tgz", wantURL: "https://registry.npmjs.org/@corp-vue/core/-/core-3.4.0.tgz",
+		},
+		{
+			// 不是该包的文件及地址原样返回
+			from: "@vue/shared", to: "vue-shared",
+			filename: "package.json", url: "https://example.com/downloads/shared-3.4.0.tgz",
+			wantFile: "package.json", wantURL: "https://example.com/downloads/shared-3.4.0.tgz",
+		},
+	}
+	for _, tt := range tests {
+		if got := renameFile(tt.from, tt.to, tt.filename); got != tt.wantFile {
+			t.Errorf("renameFile(%q, %q, %q) = %q, want %q", tt.from, tt.to, tt.filename, got, tt.wantFile)
+		}
+		if got := renameURL(tt.from, tt.to, tt.url); got != tt.wantURL {
+			t.Errorf("renameURL(%q, %q, %q) = %q, want %q", tt.from, tt.to, tt.url, got, tt.wantURL)
+		}
+	}
+}
+
+func TestRenamePackage(t *testing.T) {
+	pkg := &Package{
+		Name: "@vue/shared",
+		Id:   "@vue/shared",
+		Versions: map[string]any{
+			"3.4.0": map[string]any{
+				"name": "@vue/shared",
+				"_id":  "@vue/shared@3.4.0",
+				"dist": map[string]any{"tarball": "https://registry.npmjs.org/@vue/shared/-/shared-3.4.0.tgz"},
+			},
+		},
+		DistFiles: map[string]DistFile{
+			"shared-3.4.0.tgz": {Url: "https://registry.npmjs.org/@vue/shared/-/shared-3.4.0.tgz", Sha: "abc"},
+		},
+		Attachments: map[string]Attachment{"shared-3.4.0.tgz": {Shasum: "abc"}},
+	}
+	renamePackage(pkg, "@vue/shared", "vue-shared")
+
+	if pkg.Name != "vue-shared" || pkg.Id != "vue-shared" {
+		t.Errorf("name = %q, _id = %q", pkg.Name, pkg.Id)
+	}
+	want := map[string]any{
+		"name": "vue-shared",
+		"_id":  "vue-shared@3.4.0",
+		"dist": map[string]any{"tarball": "https://registry.npmjs.org/vue-shared/-/vue-shared-3.4.0.tgz"},
+	}
+	if !reflect.DeepEqual(pkg.Versions["3.4.0"], want) {
+		t.Errorf("versions.3.4.0 = %v, want %v", pkg.Versions["3.4.0"], want)
+	}
+	wantDistFiles := map[string]DistFile{
+		"vue-shared-3.4.0.tgz": {Url: "https://registry.npmjs.org/vue-shared/-/vue-shared-3.4.0.tgz", Sha: "abc"},
+	}
+	if !reflect.DeepEqual(pkg.DistFiles, wantDistFiles) {
+		t.Errorf("_distfiles = %v", pkg.DistFiles)
+	}
+	if !reflect.DeepEqual(pkg.Attachments, map[string]Attachment{"vue-shared-3.4.0.tgz": {Shasum: "abc"}}) {
+		t.Errorf("_attachments = %v", pkg.Attachments)
+	}
+}
+
+func TestRenamerAlias(t *testing.T) {
+	r := &Renamer{
+		Packages:     map[string]string{"@vue/shared": "vue-shared"},
+		Scopes:       map[string]string{"@babel": "@corp-babel"},
+		Dependencies: true,
+	}
+	tests := []struct {
+		dep, spec string
+		want      string
+		changed   bool
+	}{
+		{"@vue/shared", "^3.4.0", "npm:vue-shared@^3.4.0", true},
+		{"@vue/shared", "", "npm:vue-shared@*", true},
+		{"@babel/core", "7.x", "npm:@corp-babel/core@7.x", true},
+		{"shared", "npm:@vue/shared@^3.4.0", "npm:vue-shared@^3.4.0", true},
+		{"shared", "npm:@vue/shared", "npm:vue-shared", true},
+		{"lodash", "^4.0.0", "^4.0.0", false},
+		{"shared", "npm:lodash@^4.0.0", "npm:lodash@^4.0.0", false},
+		{"@vue/shared", "github:vuejs/core", "github:vuejs/core", false},
+		{"@vue/shared", "file:../shared", "file:../shared", false},
+	}
+	for _, tt := range tests {
+		got, changed := r.alias(tt.dep, tt.spec)
+		if got != tt.want || changed != tt.changed {
+			t.Errorf("alias(%q, %q) = %q, %v, want %q, %v", tt.dep, tt.spec, got, changed, tt.want, tt.changed)
+		}
+	}
+}
+
+func TestAliasDependencies(t *testing.T) {
+	newPkg := func() *Package {
+		return &Package{Versions: map[string]any{
+			"1.0.0": map[string]any{
+				"dependencies":    map[string]any{"@vue/shared": "^3.4.0", "lodash": "^4.0.0"},
+				"devDependencies": map[string]any{"@vue/shared": "^3.4.0"},
+			},
+		}}
+	}
+	tests := []struct {
+		name    string
+		renamer *Renamer
+		want    []RewriteChange
+	}{
+		{name: "未启用", renamer: &Renamer{Packages: map[string]string{"@vue/shared": "vue-shared"}}, want: []RewriteChange{}},
+		{
+			name:    "只改写安装时使用的依赖字段",
+			renamer: &Renamer{Packages: map[string]string{"@vue/shared": "vue-shared"}, Dependencies: true},
+			want: []RewriteChange{
+				{Field: "versions.1.0.0.dependencies.@vue/shared", From: "^3.4.0", To: "npm:vue-shared@^3.4.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkg := newPkg()
+			if got := tt.renamer.aliasDependencies(pkg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("aliasDependencies() = %+v, want %+v", got, tt.want)
+			}
+			dev := pkg.Versions["1.0.0"].(map[string]any)["devDependencies"].(map[string]any)
+			if dev["@vue/shared"] != "^3.4.0" {
+				t.Errorf("devDependencies 不应改写：%v", dev)
+			}
+		})
+	}
+}
+
+func TestPlanPackageRenameScopedToUnscoped(t *testing.T) {
+	root := t.TempDir()
+	srcPath := filepath.Join(root, "patch", "@vue", "shared")
+	writePackageDir(t, srcPath, &Package{
+		Name: "@vue/shared",
+		Versions: map[string]any{
+			"3.4.0": map[string]any{
+				"name": "@vue/shared",
+				"dist": map[string]any{"tarball": "https://registry.npmjs.org/@vue/shared/-/shared-3.4.0.tgz"},
+			},
+		},
+		DistTags: map[string]string{"latest": "3.4.0"},
+	}, map[string]string{"shared-3.4.0.tgz": "content"})
+
+	targetPath := filepath.Join(root, "storage", "vue-shared")
+	plan, err := PlanPackage(srcPath, targetPath, PatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Name != "vue-shared" || plan.RenamedFrom != "@vue/shared" {
+		t.Errorf("name = %q, renamedFrom = %q", plan.Name, plan.RenamedFrom)
+	}
+	if !reflect.DeepEqual(plan.Files, []string{"vue-shared-3.4.0.tgz"}) {
+		t.Fatalf("files = %v", plan.Files)
+	}
+	// 复制时从补丁包中的原文件名读取
+	if got, want := plan.srcFile("vue-shared-3.4.0.tgz"), filepath.Join(srcPath, "shared-3.4.0.tgz"); got != want {
+		t.Errorf("srcFile() = %q, want %q", got, want)
+	}
+	tarball := manifestDist(plan.After.Versions["3.4.0"])["tarball"]
+	if tarball != "https://registry.npmjs.org/vue-shared/-/vue-shared-3.4.0.tgz" {
+		t.Errorf("tarball = %q", tarball)
+	}
+}
@@ -0,0 +1,211 @@
+package verdaccio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"verda/pkg/snapshot"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRewriteSources 未配置时默认替换的公网 registry 地址
+var DefaultRewriteSources = []string{
+	"https://registry.npmjs.org/",
+	"http://registry.npmjs.org/",
+	"https://registry.yarnpkg.com/",
+	"https://registry.npmmirror.com/",
+	"https://registry.npm.taobao.org/",
+}
+
+// ScopeRewrite scope 包的地址替换规则
+type ScopeRewrite struct {
+	// 该 scope 额外需要替换的 registry 地址（例如公司外部的私有 registry）
+	Sources []string
+	// 该 scope 的目标 registry，为空时使用 Rewriter.Target
+	Target string
+}
+
+// Rewriter 将包元数据中指向公网 registry 的地址替换为内网 registry 地址
+type Rewriter struct {
+	Target  string
+	Sources []string
+	// key 为 @scope
+	Scopes map[string]ScopeRewrite
+}
+
+// RewriteChange 一处地址替换
+type RewriteChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// normalizeRegistry 统一 registry 地址格式，以 / 结尾
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimSpace(registry)
+	if registry == "" {
+		return ""
+	}
+	return strings.TrimSuffix(registry, "/") + "/"
+}
+
+// rules 获取包 name 适用的源地址和目标地址
+func (r *Rewriter) rules(name string) ([]string, string) {
+	sources, target := r.Sources, r.Target
+	if strings.HasPrefix(name, "@") {
+		scope, _, _ := strings.Cut(name, "/")
+		if rule, ok := r.Scopes[scope]; ok {
+			sources = append(append([]string{}, rule.Sources...), sources...)
+			if rule.Target != "" {
+				target = rule.Target
+			}
+		}
+	}
+	return sources, normalizeRegistry(target)
+}
+
+// rewriteURL 替换 url 中的 registry 地址，url 不以任何源地址开头时返回 false
+func rewriteURL(url string, sources []string, target string) (string, bool) {
+	for _, source := range sources {
+		source = normalizeRegistry(source)
+		if source == "" || source == target {
+			continue
+		}
+		if strings.HasPrefix(url, source) {
+			return target + strings.TrimPrefix(url, source), true
+		}
+	}
+	return url, false
+}
+
+// Rewrite 替换 pkg 中 versions[*].dist.tarball、_distfiles[*].url 及 _distfiles[*].registry 中的 registry 地址，返回替换明细
+func (r *Rewriter) Rewrite(pkg *Package) []RewriteChange {
+	changes := make([]RewriteChange, 0)
+	if r == nil {
+		return changes
+	}
+	sources, target := r.rules(pkg.Name)
+	if target == "" {
+		return changes
+	}
+
+	for version, manifest := range pkg.Versions {
+		m, ok := manifest.(map[string]any)
+		if !ok {
+			continue
+		}
+		dist, ok := m["dist"].(map[string]any)
+		if !ok {
+			continue
+		}
+		tarball, _ := dist["tarball"].(string)
+		if to, ok := rewriteURL(tarball, sources, target); ok {
+			dist["tarball"] = to
+			changes = append(changes, RewriteChange{Field: "versions." + version + ".dist.tarball", From: tarball, To: to})
+		}
+	}
+
+	for filename, distFile := range pkg.DistFiles {
+		if to, ok := rewriteURL(distFile.Url, sources, target); ok {
+			changes = append(changes, RewriteChange{Field: "_distfiles." + filename + ".url", From: distFile.Url, To: to})
+			distFile.Url = to
+		}
+		// registry 通常为 uplink 名称，只替换地址形式的值
+		if to, ok := rewriteURL(normalizeRegistry(distFile.Registry), sources, target); ok {
+			changes = append(changes, RewriteChange{Field: "_distfiles." + filename + ".registry", From: distFile.Registry, To: to})
+			distFile.Registry = to
+		}
+		pkg.DistFiles[filename] = distFile
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+type RewriteMessage struct {
+	Pkg           string
+	RewriteResult string
+	Error         string
+	Changes       []RewriteChange
+	Total         int64
+	Progress      int64
+}
+
+// RewriteStorage 替换 storage 中所有包的 registry 地址，每处理完一个包向 channel 发送一条消息，处理结束后关闭 channel。
+// dryRun 为 true 时只生成替换明细，不写入文件；snap 不为 nil 时在修改前记录包的原有状态
+func RewriteStorage(ctx context.Context, r *Rewriter, dryRun bool, snap *snapshot.Snapshot, opts WorkerOptions, channel chan<- RewriteMessage) error {
+	defer close(channel)
+
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+	names, err := ListPackageDirs(storagePath)
+	if err != nil {
+		return err
+	}
+
+	var (
+		total    = int64(len(names))
+		progress int64
+		failures int64
+	)
+	runPool(ctx, opts, len(names), func(i int) {
+		name := names[i]
+		pkgPath := filepath.Join(storagePath, name)
+		msg := RewriteMessage{Pkg: name, RewriteResult: "unchanged", Total: total}
+
+		err := ctx.Err()
+		if err == nil {
+			msg.Changes, err = rewritePackage(r, name, pkgPath, dryRun, snap)
+			if err == nil && len(msg.Changes) > 0 {
+				msg.RewriteResult = "rewritten"
+			}
+		}
+		if err != nil {
+			atomic.AddInt64(&failures, 1)
+			msg.RewriteResult = "fail"
+			msg.Error = err.Error()
+		}
+		msg.Progress = atomic.AddInt64(&progress, 1)
+		channel <- msg
+	})
+
+	if err = ctx.Err(); err != nil {
+		return errors.Wrap(err, "替换地址已取消")
+	}
+	if failures > 0 {
+		return errors.Errorf("%d/%d 个包替换地址失败", failures, total)
+	}
+	return nil
+}
+
+func rewritePackage(r *Rewriter, name, pkgPath string, dryRun bool, snap *snapshot.Snapshot) ([]RewriteChange, error) {
+	// 没有 package.json 的目录（如只有 tgz 的残留目录）不处理
+	if _, err := os.Stat(filepath.Join(pkgPath, "package.json")); err != nil {
+		return []RewriteChange{}, nil
+	}
+	pkg, err := GetPackage(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	changes := r.Rewrite(pkg)
+	if dryRun || len(changes) == 0 {
+		return changes, nil
+	}
+	if snap != nil {
+		if err = snap.Record(name, pkgPath, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err = savePackage(pkgPath, pkg); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
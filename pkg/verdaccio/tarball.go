@@ -0,0 +1,219 @@
+package verdaccio
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"verda/utils"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
+)
+
+// 读取 tarball 中 README 的最大长度
+const maxReadmeSize = 1 << 20
+
+// Tarball npm pack 生成的依赖包文件
+type Tarball struct {
+	Path      string
+	Name      string
+	Version   string
+	Manifest  map[string]any
+	Readme    string
+	Shasum    string
+	Integrity string
+}
+
+// ReadTarball 读取 tarball 根目录（通常为 package/）下的 package.json 和 README，并计算 shasum、integrity
+func ReadTarball(tarballPath string) (*Tarball, error) {
+	shasum, integrity, err := utils.FileChecksums(tarballPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "计算校验和失败：%s", tarballPath)
+	}
+
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "读取 tarball 失败：%s", tarballPath)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "不是有效的 tgz 文件：%s", tarballPath)
+	}
+	defer gz.Close()
+
+	t := &Tarball{Path: tarballPath, Shasum: shasum, Integrity: integrity}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "读取 tarball 失败：%s", tarballPath)
+		}
+		// 只读取根目录下的文件
+		dir, name := path.Split(path.Clean(header.Name))
+		if strings.Count(dir, "/") != 1 || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case "package.json":
+			if t.Manifest != nil && dir != "package/" {
+				continue
+			}
+			manifest := make(map[string]any)
+			if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return nil, errors.Wrapf(err, "无法解析 tarball 中的 package.json：%s", tarballPath)
+			}
+			t.Manifest = manifest
+		case "readme.md", "readme", "readme.markdown", "readme.txt":
+			if t.Readme != "" {
+				continue
+			}
+			content, err := io.ReadAll(io.LimitReader(tr, maxReadmeSize))
+			if err != nil {
+				return nil, errors.Wrapf(err, "读取 tarball 中的 README 失败：%s", tarballPath)
+			}
+			t.Readme = string(content)
+		}
+	}
+
+	if t.Manifest == nil {
+		return nil, errors.Errorf("tarball 中不存在 package.json：%s", tarballPath)
+	}
+	t.Name, _ = t.Manifest["name"].(string)
+	t.Version, _ = t.Manifest["version"].(string)
+	if !validPackageName(t.Name) {
+		return nil, errors.Errorf("非法的包名 %q：%s", t.Name, tarballPath)
+	}
+	if _, err = semver.StrictNewVersion(t.Version); err != nil {
+		return nil, errors.Errorf("非法的版本号 %q：%s", t.Version, tarballPath)
+	}
+	return t, nil
+}
+
+func validPackageName(name string) bool {
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `\ `) {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	if strings.HasPrefix(name, "@") {
+		return len(parts) == 2 && validNamePart(parts[0][1:]) && validNamePart(parts[1])
+	}
+	return len(parts) == 1 && validNamePart(name)
+}
+
+// validNamePart 包名或 scope 的一段，不能为空及 . 和 ..，否则会解析为 storage 中的其它目录
+func validNamePart(part string) bool {
+	return part != "" && part != "." && part != ".."
+}
+
+// DistFilename 依赖包在 storage 中的文件名，scope 包不包含 scope 部分
+func DistFilename(name, version string) string {
+	return path.Base(name) + "-" + version + ".tgz"
+}
+
+// TarballURL 依赖包在 registry 上的下载地址
+func TarballURL(registry, name, version string) string {
+	return strings.TrimSuffix(registry, "/") + "/" + name + "/-/" + DistFilename(name, version)
+}
+
+// StageTarballs 将 tarball 整理为补丁目录 patchDir（与补丁包中的 storage-patch 目录结构相同），tarball 文件会被移动到补丁目录中。
+// 之后按补丁包合并到 storage，同样经过冲突检测、准入策略、保护检查，并记录快照和 patch 历史。
+// 与 npm publish 一致：稳定版本高于 storage 中当前的 latest 时更新 latest
+func StageTarballs(tarballs []*Tarball, registry, patchDir string) error {
+	storagePath, err := GetStoragePath()
+	if err != nil {
+		return errors.WithMessagef(err, "无法获取verdaccio storage path：%s", storagePath)
+	}
+
+	now := time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	staged := make(map[string]*Package)
+	for _, t := range tarballs {
+		pkg, ok := staged[t.Name]
+		if !ok {
+			if pkg, err = stagedPackage(filepath.Join(storagePath, t.Name), t.Name, now); err != nil {
+				return err
+			}
+			staged[t.Name] = pkg
+		}
+
+		filename := DistFilename(t.Name, t.Version)
+		manifest := t.Manifest
+		delete(manifest, "readme")
+		manifest["_id"] = t.Name + "@" + t.Version
+		manifest["dist"] = map[string]any{
+			"shasum":    t.Shasum,
+			"integrity": t.Integrity,
+			"tarball":   TarballURL(registry, t.Name, t.Version),
+		}
+		pkg.Versions[t.Version] = manifest
+		pkg.Time[t.Version] = now
+		if latest, ok := pkg.DistTags["latest"]; !ok || isNewerStable(t.Version, latest) {
+			pkg.DistTags["latest"] = t.Version
+		}
+		pkg.Attachments[filename] = Attachment{Shasum: t.Shasum}
+		if t.Readme != "" {
+			pkg.Readme = t.Readme
+		}
+
+		pkgPath := filepath.Join(patchDir, t.Name)
+		if err = os.MkdirAll(pkgPath, 0755); err != nil {
+			return errors.Wrapf(err, "无法创建目录：%s", pkgPath)
+		}
+		if err = os.Rename(t.Path, filepath.Join(pkgPath, filename)); err != nil {
+			return errors.Wrapf(err, "移动 %s 失败", filename)
+		}
+	}
+
+	for name, pkg := range staged {
+		if err = savePackage(filepath.Join(patchDir, name), pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stagedPackage 创建补丁目录中包 name 的 package.json，storage 中已存在该包时沿用其 latest、created，
+// 合并时不会回退 latest
+func stagedPackage(storagePkgPath, name, now string) (*Package, error) {
+	pkg := &Package{Name: name, Id: name, Uplinks: map[string]any{}}
+	pkg.initMaps()
+	pkg.Time["created"] = now
+	pkg.Time["modified"] = now
+	if !utils.PathExists(filepath.Join(storagePkgPath, "package.json")) {
+		return pkg, nil
+	}
+	current, err := GetPackage(storagePkgPath)
+	if err != nil {
+		return nil, err
+	}
+	if latest, ok := current.DistTags["latest"]; ok {
+		pkg.DistTags["latest"] = latest
+	}
+	if created, ok := current.Time["created"]; ok {
+		pkg.Time["created"] = created
+	}
+	return pkg, nil
+}
+
+// isNewerStable version 是否为高于 current 的稳定版本
+func isNewerStable(version, current string) bool {
+	v, err := semver.NewVersion(version)
+	if err != nil || v.Prerelease() != "" {
+		return false
+	}
+	c, err := semver.NewVersion(current)
+	if err != nil {
+		return true
+	}
+	return v.GreaterThan(c)
+}
@@ -0,0 +1,241 @@
+package verdaccio
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"verda/utils"
+)
+
+// writeTgz 在 dir 中写入 tgz 文件 name，files 为 tarball 中的文件（路径 -> 内容）
+func writeTgz(t *testing.T, dir, name string, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		if err = tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(body))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTarball(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		wantName    string
+		wantVersion string
+		wantReadme  string
+		wantErr     bool
+	}{
+		{
+			name: "普通包",
+			files: map[string]string{
+				"package/package.json": `{"name":"lodash","version":"4.17.21"}`,
+				"package/README.md":    "# lodash",
+			},
+			wantName: "lodash", wantVersion: "4.17.21", wantReadme: "# lodash",
+		},
+		{
+			name:     "scope 包及非 package/ 根目录",
+			files:    map[string]string{"ui/package.json": `{"name":"@corp/ui","version":"1.0.0-beta.1"}`},
+			wantName: "@corp/ui", wantVersion: "1.0.0-beta.1",
+		},
+		{
+			name: "忽略子目录中的 package.json",
+			files: map[string]string{
+				"package/package.json":                  `{"name":"demo","version":"1.0.0"}`,
+				"package/node_modules/dep/package.json": `{"name":"dep","version":"2.0.0"}`,
+			},
+			wantName: "demo", wantVersion: "1.0.0",
+		},
+		{
+			name:    "缺少 package.json",
+			files:   map[string]string{"package/index.js": ""},
+			wantErr: true,
+		},
+		{
+			name:    "非法的包名",
+			files:   map[string]string{"package/package.json": `{"name":"../evil","version":"1.0.0"}`},
+			wantErr: true,
+		},
+		{
+			name:    "非法的 scope 包名",
+			files:   map[string]string{"package/package.json": `{"name":"@corp/ui/x","version":"1.0.0"}`},
+			wantErr: true,
+		},
+		{
+			name:    "包名为 .",
+			files:   map[string]string{"package/package.json": `{"name":".","version":"1.0.0"}`},
+			wantErr: true,
+		},
+		{
+			name:    "scope 为 .",
+			files:   map[string]string{"package/package.json": `{"name":"@./ui","version":"1.0.0"}`},
+			wantErr: true,
+		},
+		{
+			name:    "scope 包名为 ..",
+			files:   map[string]string{"package/package.json": `{"name":"@corp/..","version":"1.0.0"}`},
+			wantErr: true,
+		},
+		{
+			name:    "非法的版本号",
+			files:   map[string]string{"package/package.json": `{"name":"demo","version":"latest"}`},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTgz(t, t.TempDir(), "demo.tgz", tt.files)
+			got, err := ReadTarball(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadTarball() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.wantName || got.Version != tt.wantVersion || got.Readme != tt.wantReadme {
+				t.Errorf("ReadTarball() = %s@%s readme %q", got.Name, got.Version, got.Readme)
+			}
+			shasum, integrity, _ := utils.FileChecksums(path)
+			if got.Shasum != shasum || got.Integrity != integrity {
+				t.Errorf("checksums = %s %s, want %s %s", got.Shasum, got.Integrity, shasum, integrity)
+			}
+		})
+	}
+}
+
+func TestReadTarballNotGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.tgz")
+	if err := os.WriteFile(path, []byte("not a tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTarball(path); err == nil {
+		t.Error("不是 tgz 文件时应返回错误")
+	}
+}
+
+func TestStageTarballs(t *testing.T) {
+	storagePath := t.TempDir()
+	t.Setenv("VERDACCIO_STORAGE_PATH", storagePath)
+	// storage 中已有 demo，latest 为 2.0.0
+	writePackageDir(t, filepath.Join(storagePath, "demo"), &Package{
+		Name:     "demo",
+		Versions: map[string]any{"2.0.0": testManifest("demo", "2.0.0", nil)},
+		Time:     map[string]string{"created": "2024-01-01T00:00:00.000Z"},
+		DistTags: map[string]string{"latest": "2.0.0"},
+	}, nil)
+
+	dir := t.TempDir()
+	var tarballs []*Tarball
+	for _, spec := range []struct{ name, version string }{
+		{"demo", "1.5.0"},
+		{"demo", "3.0.0-beta.1"},
+		{"@corp/ui", "1.0.0"},
+		{"@corp/ui", "1.1.0"},
+	} {
+		path := writeTgz(t, dir, DistFilename(spec.name, spec.version), map[string]string{
+			"package/package.json": `{"name":"` + spec.name + `","version":"` + spec.version + `"}`,
+			"package/README.md":    "# " + spec.name,
+		})
+		tarball, err := ReadTarball(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tarballs = append(tarballs, tarball)
+	}
+
+	patchDir := filepath.Join(t.TempDir(), "storage-patch")
+	if err := StageTarballs(tarballs, "http://npm.local/", patchDir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		files    []string
+		latest   string
+		created  string
+		versions int
+	}{
+		// 低于 storage 中 latest 的版本及预发布版本不更新 latest，沿用原有的 created
+		{name: "demo", files: []string{"demo-1.5.0.tgz", "demo-3.0.0-beta.1.tgz"}, latest: "2.0.0", created: "2024-01-01T00:00:00.000Z", versions: 2},
+		{name: "@corp/ui", files: []string{"ui-1.0.0.tgz", "ui-1.1.0.tgz"}, latest: "1.1.0", versions: 2},
+	}
+	for _, tt := range tests {
+		pkgPath := filepath.Join(patchDir, tt.name)
+		pkg, err := GetPackage(pkgPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkg.Versions) != tt.versions || pkg.DistTags["latest"] != tt.latest || pkg.Readme != "# "+tt.name {
+			t.Errorf("%s: versions = %d, latest = %q, readme = %q", tt.name, len(pkg.Versions), pkg.DistTags["latest"], pkg.Readme)
+		}
+		if tt.created != "" && pkg.Time["created"] != tt.created {
+			t.Errorf("%s: created = %q, want %q", tt.name, pkg.Time["created"], tt.created)
+		}
+		for _, file := range tt.files {
+			if !utils.PathExists(filepath.Join(pkgPath, file)) {
+				t.Errorf("%s 应移动到补丁目录", file)
+			}
+			if utils.PathExists(filepath.Join(dir, file)) {
+				t.Errorf("%s 应从原位置移走", file)
+			}
+		}
+	}
+
+	ui, _ := GetPackage(filepath.Join(patchDir, "@corp/ui"))
+	dist := manifestDist(ui.Versions["1.0.0"])
+	shasum, integrity, _ := utils.FileChecksums(filepath.Join(patchDir, "@corp/ui", "ui-1.0.0.tgz"))
+	if dist["tarball"] != "http://npm.local/@corp/ui/-/ui-1.0.0.tgz" || dist["shasum"] != shasum || dist["integrity"] != integrity {
+		t.Errorf("dist = %v", dist)
+	}
+	if _, ok := ui.Versions["1.0.0"].(map[string]any)["readme"]; ok {
+		t.Error("版本元数据中不应包含 readme")
+	}
+}
+
+func TestValidPackageName(t *testing.T) {
+	tests := map[string]bool{
+		"lodash":     true,
+		"@corp/ui":   true,
+		"a.b":        true,
+		"":           false,
+		".":          false,
+		"..":         false,
+		"@./ui":      false,
+		"@../ui":     false,
+		"@corp/.":    false,
+		"@corp/..":   false,
+		"@/ui":       false,
+		"@corp":      false,
+		"a/b":        false,
+		"../evil":    false,
+		"@corp/ui/x": false,
+		"has space":  false,
+		`back\slash`: false,
+	}
+	for name, want := range tests {
+		if got := validPackageName(name); got != want {
+			t.Errorf("validPackageName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
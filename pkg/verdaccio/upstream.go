@@ -0,0 +1,44 @@
+package verdaccio
+
+import (
+	"verda/pkg/upstream"
+
+	"github.com/samber/lo"
+)
+
+// collectUpstream 获取 pkg 中本地没有 tarball 的版本（上游存在但未同步）及指向这些版本的 dist-tags，local 为本地存在的版本
+func collectUpstream(pkg *Package, local []string) *upstream.Package {
+	seen := &upstream.Package{Name: pkg.Name, Versions: make([]upstream.Version, 0), DistTags: make(map[string]string)}
+	for version, manifest := range pkg.Versions {
+		if lo.Contains(local, version) {
+			continue
+		}
+		v := upstream.Version{Version: version, Time: pkg.Time[version]}
+		dist := manifestDist(manifest)
+		v.Tarball, v.Shasum, v.Integrity = dist["tarball"], dist["shasum"], dist["integrity"]
+		if m, ok := manifest.(map[string]any); ok {
+			v.Deprecated, _ = m["deprecated"].(string)
+		}
+		seen.Versions = append(seen.Versions, v)
+	}
+	for tag, version := range pkg.DistTags {
+		if _, ok := pkg.Versions[version]; ok && !lo.Contains(local, version) {
+			seen.DistTags[tag] = version
+		}
+	}
+	return seen
+}
+
+// mergeUpstream 将 other 中的版本及 dist-tags 合并到 seen，已存在的版本保留 seen 中的信息
+func mergeUpstream(seen, other *upstream.Package) {
+	for _, v := range other.Versions {
+		if !lo.ContainsBy(seen.Versions, func(s upstream.Version) bool { return s.Version == v.Version }) {
+			seen.Versions = append(seen.Versions, v)
+		}
+	}
+	for tag, version := range other.DistTags {
+		if _, ok := seen.DistTags[tag]; !ok {
+			seen.DistTags[tag] = version
+		}
+	}
+}
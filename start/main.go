@@ -4,11 +4,45 @@ import (
 	"flag"
 	"fmt"
 	"github.com/gofiber/fiber/v2/log"
+	"os"
+	"time"
 )
 
+var Config = flag.String("config", "", "verda 配置文件路径（yaml），为空时使用默认配置")
 var Mode = flag.String("mode", "production", "运行模式，development-开发环境，production-生产环境")
 var Port = flag.String("port", "3000", "服务监听的端口，默认为3000")
 var Debug = flag.Bool("debug", false, "是否开启debug模式")
+var Registry = flag.String("registry", "http://localhost:4873/", "内网 verdaccio 地址，用于生成 tarball 下载地址；显式指定且未配置 rewrite.target 时作为 registry 地址替换的目标")
+var ImportRoots = flag.String("import-roots", "", "允许从服务器路径导入补丁包的目录，多个目录用英文逗号分隔；为空时禁用导入接口及 -import")
+var Import = flag.String("import", "", "从服务器路径导入补丁包（zip/tar 或已解压的目录）后退出，路径需位于 -import-roots 下；服务正在执行任务时拒绝导入")
+var Operator = flag.String("operator", os.Getenv("USER"), "通过 -import 导入补丁包时记录的操作人")
+var MakeManifest = flag.String("make-manifest", "", "为补丁包目录（包含 storage-patch 的目录）生成 verda-bundle.json 清单后退出")
+var ManifestSource = flag.String("manifest-source", "https://registry.npmjs.org/", "生成清单时记录的外网 registry 地址")
+var ManifestCreator = flag.String("manifest-creator", os.Getenv("USER"), "生成清单时记录的创建者")
+var ManifestSequence = flag.Int64("manifest-sequence", 0, "生成清单时记录的补丁包序号，为 0 且指定了 -manifest-previous 时使用上一个补丁包的序号加 1")
+var ManifestPrevious = flag.String("manifest-previous", "", "生成清单时指定上一个补丁包（目录或 verda-bundle.json），记录其清单的哈希")
+var Force = flag.Bool("force", false, "通过 -import 导入补丁包时忽略序号检查，用于有意乱序或重复应用补丁包")
+var UploadMaxSize = flag.String("upload-max-size", "0", "单个上传的最大大小，支持 K/M/G/T 单位，0 表示不限制")
+var UploadMaxInflight = flag.String("upload-max-inflight", "0", "所有进行中的上传的总大小上限，支持 K/M/G/T 单位，0 表示不限制")
+var ExpansionFactor = flag.Float64("expansion-factor", 2, "补丁包解压后相对压缩包的膨胀系数，用于预估所需磁盘空间")
+var DataDir = flag.String("data", "data", "数据目录，用于保存任务记录等数据")
+var ConflictPolicy = flag.String("conflict-policy", "reject", "补丁包中的版本与已有版本内容不一致时的处理策略：reject-保留已有版本，quarantine-保留已有版本并隔离冲突版本，fail-整个包不合并")
+var Concurrency = flag.Int("concurrency", 4, "patch、整理 storage 时同时处理的包数量")
+var IOLimit = flag.String("io-limit", "0", "patch 时读取 tarball 的速度上限（每秒），支持 K/M/G/T 单位，0 表示不限制")
+var UploadTTL = flag.Duration("upload-ttl", 24*time.Hour, "上传会话的过期时间，超过该时间未更新的分片会被清理")
+var GCInterval = flag.Duration("gc-interval", 10*time.Minute, "清理过期分片和临时目录的间隔")
+
+// ExplicitRegistry 在命令行中显式指定了 -registry 时返回其值，否则返回空字符串。
+// 默认值只用于生成 tarball 下载地址，不作为 registry 地址替换的目标
+func ExplicitRegistry() string {
+	var registry string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "registry" {
+			registry = *Registry
+		}
+	})
+	return registry
+}
 
 func init() {
 	flag.Parse()
@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatUnknown = ""
+	FormatZip     = "zip"
+	FormatTar     = "tar"
+	FormatTarGz   = "tar.gz"
+	FormatTarZst  = "tar.zst"
+)
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+)
+
+// DetectArchive 根据文件头的魔数判断压缩包格式
+func DetectArchive(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return FormatUnknown, err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, zipEmptyMagic):
+		return FormatZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return FormatTarZst, nil
+	case len(header) >= 262 && bytes.Equal(header[257:262], tarMagic):
+		return FormatTar, nil
+	}
+	return FormatUnknown, nil
+}
+
+// Extract 自动识别压缩包格式（zip、tar、tar.gz、tar.zst）并解压到 target
+func Extract(path, target string) error {
+	format, err := DetectArchive(path)
+	if err != nil {
+		return errors.Wrapf(err, "无法识别压缩包格式：%s", path)
+	}
+	if format == FormatZip {
+		return Unzip(path, target)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch format {
+	case FormatTar:
+		return Untar(file, target)
+	case FormatTarGz:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return errors.Wrap(err, "gzip 解压失败")
+		}
+		defer gz.Close()
+		return Untar(gz, target)
+	case FormatTarZst:
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return errors.Wrap(err, "zstd 解压失败")
+		}
+		defer zr.Close()
+		return Untar(zr, target)
+	}
+	return errors.New("不支持的压缩包格式，仅支持 zip、tar、tar.gz、tar.zst")
+}
+
+// Untar 以流的方式解压 tar 到 target，只解压普通文件和目录，忽略链接等特殊文件
+func Untar(r io.Reader, target string) error {
+	tr := tar.NewReader(r)
+	cleanTarget := filepath.Clean(target)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "读取 tar 失败")
+		}
+
+		filePath := filepath.Join(target, header.Name)
+		// 形如 ./ 的根目录条目
+		if filePath == cleanTarget && header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if !strings.HasPrefix(filePath, cleanTarget+string(os.PathSeparator)) {
+			return errors.New("路径解析错误")
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, os.ModePerm); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+				return err
+			}
+			dstFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(dstFile, tr)
+			dstFile.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
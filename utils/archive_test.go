@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	body     string
+	linkname string
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.body)), Linkname: e.linkname}
+		if e.typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		// 解压后应存在的文件及内容
+		want map[string]string
+		// 解压后不应存在的路径（相对 target 的父目录）
+		absent  []string
+		wantErr bool
+	}{
+		{
+			name: "普通文件和目录",
+			entries: []tarEntry{
+				{name: "./", typeflag: tar.TypeDir},
+				{name: "storage-patch/", typeflag: tar.TypeDir},
+				{name: "storage-patch/lodash/package.json", typeflag: tar.TypeReg, body: "{}"},
+			},
+			want: map[string]string{"storage-patch/lodash/package.json": "{}"},
+		},
+		{
+			name:    "相对路径越出目标目录",
+			entries: []tarEntry{{name: "../escape.txt", typeflag: tar.TypeReg, body: "x"}},
+			absent:  []string{"escape.txt"},
+			wantErr: true,
+		},
+		{
+			name:    "中间包含 .. 越出目标目录",
+			entries: []tarEntry{{name: "storage-patch/../../escape.txt", typeflag: tar.TypeReg, body: "x"}},
+			absent:  []string{"escape.txt"},
+			wantErr: true,
+		},
+		{
+			name: "忽略符号链接和硬链接",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "../../etc/passwd"},
+				{name: "hard", typeflag: tar.TypeLink, linkname: "../escape.txt"},
+				{name: "file.txt", typeflag: tar.TypeReg, body: "ok"},
+			},
+			want:   map[string]string{"file.txt": "ok"},
+			absent: []string{"target/link", "target/hard"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			target := filepath.Join(root, "target")
+			err := Untar(bytes.NewReader(buildTar(t, tt.entries)), target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Untar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, body := range tt.want {
+				content, err := os.ReadFile(filepath.Join(target, name))
+				if err != nil || string(content) != body {
+					t.Errorf("%s = %q, %v, want %q", name, content, err, body)
+				}
+			}
+			for _, name := range tt.absent {
+				if _, err := os.Lstat(filepath.Join(root, name)); err == nil {
+					t.Errorf("%s 不应存在", name)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(buildTar(t, []tarEntry{{name: "a/b.txt", typeflag: tar.TypeReg, body: "hello"}})); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "bundle.tgz")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if format, err := DetectArchive(path); err != nil || format != FormatTarGz {
+		t.Fatalf("DetectArchive() = %q, %v", format, err)
+	}
+	target := filepath.Join(dir, "out")
+	if err := Extract(path, target); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(target, "a", "b.txt")); string(content) != "hello" {
+		t.Errorf("a/b.txt = %q", content)
+	}
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// FileChecksums 计算文件的 sha1（npm shasum，十六进制）和 sha512（npm integrity，SRI 格式）
+func FileChecksums(path string) (shasum string, integrity string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+	return Checksums(file)
+}
+
+// Checksums 计算 r 中全部内容的 sha1 和 sha512，格式同 FileChecksums
+func Checksums(r io.Reader) (shasum string, integrity string, err error) {
+	sha1Hash, sha512Hash := sha1.New(), sha512.New()
+	if _, err = io.Copy(io.MultiWriter(sha1Hash, sha512Hash), r); err != nil {
+		return "", "", err
+	}
+	shasum = hex.EncodeToString(sha1Hash.Sum(nil))
+	integrity = "sha512-" + base64.StdEncoding.EncodeToString(sha512Hash.Sum(nil))
+	return shasum, integrity, nil
+}
@@ -0,0 +1,35 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// DiskUsage 获取 path 所在磁盘的可用空间（字节）及设备号，path 不存在时使用最近的已存在的上级目录
+func DiskUsage(path string) (free uint64, device uint64, err error) {
+	path = existingParent(path)
+
+	var fs syscall.Statfs_t
+	if err = syscall.Statfs(path, &fs); err != nil {
+		return 0, 0, err
+	}
+	var st syscall.Stat_t
+	if err = syscall.Stat(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return uint64(fs.Bavail) * uint64(fs.Bsize), uint64(st.Dev), nil
+}
+
+func existingParent(path string) string {
+	path, _ = filepath.Abs(path)
+	for !PathExists(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
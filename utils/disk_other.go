@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package utils
+
+import "github.com/pkg/errors"
+
+// DiskUsage 当前平台不支持获取磁盘可用空间
+func DiskUsage(path string) (free uint64, device uint64, err error) {
+	return 0, 0, errors.New("当前平台不支持获取磁盘可用空间")
+}
@@ -72,3 +72,83 @@ func Copy(from, to string) error {
 	}
 	return nil
 }
+
+// DirSize 计算文件或文件夹的总大小（字节）
+func DirSize(path string) int64 {
+	var size int64
+	_ = filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// WriteFileAtomic 先写入同目录下的临时文件再重命名，避免写入中断时留下不完整的文件
+func WriteFileAtomic(path string, content []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// CopyFileAtomic 将文件复制到同目录下的临时文件后再重命名为 to，避免复制中断时留下不完整的文件
+func CopyFileAtomic(from, to string) error {
+	file, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return WriteAtomic(bufio.NewReader(file), to)
+}
+
+// WriteAtomic 将 r 中的内容写入 to，同样先写入临时文件再重命名
+func WriteAtomic(r io.Reader, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(to), "."+filepath.Base(to)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err = os.Rename(tmpPath, to); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+package utils
+
+import "github.com/pkg/errors"
+
+// ErrLocked 文件锁已被其他进程持有
+var ErrLocked = errors.New("文件已被其他进程锁定")
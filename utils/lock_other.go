@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package utils
+
+// TryLock 当前平台不支持文件锁，总是获取成功
+func TryLock(path string) (unlock func(), err error) {
+	return func() {}, nil
+}
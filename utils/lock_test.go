@@ -0,0 +1,26 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.lock")
+	unlock, err := TryLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("锁被持有时 TryLock() error = %v, want ErrLocked", err)
+	}
+	unlock()
+	unlock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("释放后 TryLock() error = %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// TryLock 以非阻塞方式获取文件 path 的排他锁（flock），文件不存在时创建；锁已被持有时返回 ErrLocked。
+// 调用 unlock 释放锁，进程退出时锁也会自动释放
+func TryLock(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "无法打开锁文件：%s", path)
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, errors.Wrapf(err, "无法锁定文件：%s", path)
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var sizeUnits = []string{"B", "K", "M", "G", "T"}
+
+// ParseSize 解析带单位的大小，例如 512、100K、20M、5G、1.5T（不区分大小写，可带 B/iB 后缀），单位为 1024 进制
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "IB"), "B")
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for i := len(sizeUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(s, sizeUnits[i]) {
+			s = strings.TrimSuffix(s, sizeUnits[i])
+			multiplier = int64(1) << (10 * i)
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || value < 0 {
+		return 0, errors.Errorf("无法解析大小：%s", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatSize 将字节数格式化为便于阅读的大小
+func FormatSize(size int64) string {
+	value := float64(size)
+	i := 0
+	for value >= 1024 && i < len(sizeUnits)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.2f%s", value, sizeUnits[i])
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter 限制读写速度（字节/秒），多个 goroutine 共享同一个限速器时限制的是总速度
+type RateLimiter struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+// NewRateLimiter 创建限速器，bytesPerSecond <= 0 时返回 nil，表示不限速
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: bytesPerSecond}
+}
+
+// Wait 为 n 字节预留额度，必要时等待，nil 限速器直接返回
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	start := l.next
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+
+	if d := start.Sub(now); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Reader 返回受限速的 Reader，nil 限速器返回 r 本身
+func (l *RateLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *RateLimiter
+}
+
+// 单次读取的上限，避免一次预留过多额度导致长时间阻塞
+const limitedReadSize = 32 * 1024
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedReadSize {
+		p = p[:limitedReadSize]
+	}
+	n, err := r.r.Read(p)
+	r.l.Wait(n)
+	return n, err
+}
@@ -1,4 +1,5 @@
 export * from './adjust-progress-modal'
+export * from './package-adjust-action'
 export * from './package-card'
 export * from './package-filters'
 export * from './package-list'
@@ -0,0 +1,184 @@
+import { Button, message, Modal, Spin } from 'antd'
+import React, { useState } from 'react'
+import { BusinessError, request } from '@/http'
+
+export interface DistTagChange {
+  tag: string
+  from: string
+  to: string
+}
+
+export interface PackageState {
+  versions: string[]
+  distTags: Record<string, string>
+}
+
+export interface AdjustChange {
+  name: string
+  removedVersions: string[]
+  removedTime: string[]
+  updatedTime: string[]
+  removedAttachments: string[]
+  removedDistFiles: string[]
+  distTags: DistTagChange[]
+  normalized: string[]
+  before: PackageState
+  after: PackageState
+}
+
+export interface AdjustPackageResult {
+  dryRun: boolean
+  adjusted: number
+  packages: AdjustChange[]
+}
+
+interface AdjustJob {
+  id: string
+  state: 'queued' | 'running' | 'succeeded' | 'failed' | 'cancelled'
+  error?: string
+  results?: { pkg: string, result: string, error?: string, detail?: AdjustChange }[]
+}
+
+const FinishedStates = ['succeeded', 'failed', 'cancelled']
+
+function adjustPath(name: string) {
+  // 对 scope 中的 `/` 编码以避免 URL 路径冲突
+  return `api/storage/adjust/packages/${name.split('/').map(encodeURIComponent).join('/')}`
+}
+
+/** 预览直接返回整理前后的差异 */
+function previewAdjust(name: string) {
+  return request<AdjustPackageResult>(`${adjustPath(name)}?dryRun=true`, { method: 'POST' })
+}
+
+/** 写入时提交整理任务 */
+function submitAdjust(name: string) {
+  return request<AdjustJob>(adjustPath(name), { method: 'POST' })
+}
+
+/** 整理在任务队列中执行，轮询直到任务结束，失败时抛出 BusinessError */
+async function waitAdjustJob(id: string) {
+  for (;;) {
+    const job = await request<AdjustJob>(`api/jobs/${id}`)
+    if (FinishedStates.includes(job.state)) {
+      if (job.state !== 'succeeded')
+        throw new BusinessError(job.results?.find(r => r.error)?.error || job.error || '整理失败', -1)
+      return job
+    }
+    await new Promise(resolve => setTimeout(resolve, 500))
+  }
+}
+
+export interface PackageAdjustActionProps {
+  name: string
+  onAdjusted?: () => void
+}
+
+/** 整理单个包：先预览将被删除的内容及 dist-tags 变化，确认后提交整理任务写入（写入前记录快照，可回滚） */
+export const PackageAdjustAction: React.FC<PackageAdjustActionProps> = ({ name, onAdjusted }) => {
+  const [msg, contextHolder] = message.useMessage()
+  const [open, setOpen] = useState(false)
+  const [loading, setLoading] = useState(false)
+  const [applying, setApplying] = useState(false)
+  // null 表示无需整理
+  const [preview, setPreview] = useState<AdjustChange | null>()
+
+  const handlePreview = async () => {
+    setOpen(true)
+    setLoading(true)
+    setPreview(undefined)
+    try {
+      const result = await previewAdjust(name)
+      setPreview(result.adjusted > 0 ? result.packages[0] : null)
+    }
+    catch (error) {
+      setOpen(false)
+      msg.error(error instanceof BusinessError ? error.message : '获取整理预览失败')
+    }
+    finally {
+      setLoading(false)
+    }
+  }
+
+  const handleApply = async () => {
+    setApplying(true)
+    try {
+      const job = await waitAdjustJob((await submitAdjust(name)).id)
+      msg.success(job.results?.some(r => r.result === 'adjusted') ? '整理完成' : '无需整理')
+      setOpen(false)
+      onAdjusted?.()
+    }
+    catch (error) {
+      msg.error(error instanceof BusinessError ? error.message : '整理失败')
+    }
+    finally {
+      setApplying(false)
+    }
+  }
+
+  return (
+    <>
+      {contextHolder}
+      <Button size="small" onClick={handlePreview}>整理</Button>
+      <Modal
+        title={`整理 ${name}`}
+        open={open}
+        onCancel={() => setOpen(false)}
+        onOk={handleApply}
+        okText="确认整理"
+        okButtonProps={{ disabled: loading || !preview }}
+        confirmLoading={applying}
+      >
+        {loading || preview === undefined
+          ? <div className="flex justify-center py-8"><Spin /></div>
+          : !preview
+              ? <p className="text-sm color-#6b7280">package.json 与本地发布版文件一致，无需整理</p>
+              : (
+                  <div className="max-h-[400px] overflow-y-auto text-sm space-y-3 cus-scrollbar">
+                    <ChangeList title="删除的版本" items={preview.removedVersions} />
+                    <ChangeList title="删除的 time 条目" items={preview.removedTime} />
+                    <ChangeList title="更新的 time 条目" items={preview.updatedTime} />
+                    <ChangeList title="删除的 _attachments" items={preview.removedAttachments} />
+                    <ChangeList title="删除的 _distfiles" items={preview.removedDistFiles} />
+                    <ChangeList
+                      title="dist-tags 变化"
+                      items={preview.distTags.map(t => `${t.tag}: ${t.from || '-'} → ${t.to || '（删除）'}`)}
+                    />
+                    <ChangeList title="清理的上游字段" items={preview.normalized} />
+                    <StateCompare before={preview.before} after={preview.after} />
+                  </div>
+                )}
+      </Modal>
+    </>
+  )
+}
+
+const ChangeList: React.FC<{ title: string, items: string[] }> = ({ title, items }) => {
+  if (items.length === 0)
+    return null
+  return (
+    <div>
+      <div className="text-xs font-600 color-#9ca3af mb-1">{title}</div>
+      <div className="flex flex-wrap gap-1.5">
+        {items.map(item => (
+          <span key={item} className="text-xs font-mono bg-gray-50 color-#374151 px-2 py-0.5 rounded border border-gray-200">{item}</span>
+        ))}
+      </div>
+    </div>
+  )
+}
+
+const StateCompare: React.FC<{ before: PackageState, after: PackageState }> = ({ before, after }) => (
+  <div className="grid grid-cols-2 gap-3 pt-2 border-t border-gray-100">
+    <StateColumn title="整理前" state={before} />
+    <StateColumn title="整理后" state={after} />
+  </div>
+)
+
+const StateColumn: React.FC<{ title: string, state: PackageState }> = ({ title, state }) => (
+  <div className="space-y-2">
+    <div className="text-xs font-600 color-#6b7280">{title}</div>
+    <ChangeList title={`版本（${state.versions.length}）`} items={state.versions} />
+    <ChangeList title="dist-tags" items={Object.entries(state.distTags).map(([tag, version]) => `${tag}: ${version}`)} />
+  </div>
+)
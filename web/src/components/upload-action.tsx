@@ -6,6 +6,24 @@ import { PatchResult } from '@/const'
 import { useComputeFileMD5 } from '@/hooks/use-compute-file-md5'
 import { BusinessError, request } from '@/http'
 
+interface UploadSession {
+  id: string
+  filename: string
+  size: number
+  chunkSize: number
+  chunkCount: number
+  md5: string
+  uploaded: number[]
+}
+
+interface Job {
+  id: string
+  state: 'queued' | 'running' | 'succeeded' | 'failed' | 'cancelled'
+  error?: string
+  total: number
+  progress: number
+}
+
 export interface UploadActionProps {
   onPatch?: (patchResult: number, message: string) => void
 }
@@ -14,40 +32,63 @@ const { Dragger } = Upload
 
 const ThemeColor = 'rgb(22, 119, 255)'
 
+const BundleExtensions = ['.zip', '.tar', '.tar.gz', '.tgz', '.tar.zst', '.tzst']
+
 export const UploadAction: React.FC<UploadActionProps> = (props) => {
   const [uploadFile, setUploadFile] = useState<UploadFile>()
   const [progressTip, setProgressTip] = useState('')
   const [isModalOpen, setIsModalOpen] = useState(false)
 
-  const patchStorage = (
-    filename: string,
-    fileList: string[],
-    md5: string,
-  ): Promise<boolean> => {
-    return request<boolean>('api/storage/patch', {
+  const initUpload = (params: {
+    filename: string
+    size: number
+    chunkSize: number
+    chunkCount: number
+    md5: string
+  }): Promise<UploadSession> => {
+    return request<UploadSession>('api/storage/uploads', {
       method: 'post',
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ filename, fileList, md5 }),
+      body: JSON.stringify(params),
     })
   }
 
-  const buildUploadAction = (chunk: {
-    index: number
-    chunkFile: Blob
-    chunkSize: number
-  }): Promise<{ filename: string }> => {
+  const uploadChunk = (sessionId: string, index: number, chunkFile: Blob) => {
     const form = new FormData()
-    form.append('index', `${chunk.index}`)
-    form.append('chunkSize', `${chunk.chunkSize}`)
-    form.append('chunkFile', chunk.chunkFile)
-    return request('api/storage/upload', {
-      method: 'post',
+    form.append('chunkFile', chunkFile)
+    return request(`api/storage/uploads/${sessionId}/chunks/${index}`, {
+      method: 'put',
       body: form,
     })
   }
 
+  const completeUpload = (sessionId: string) => {
+    return request<Job>(`api/storage/uploads/${sessionId}/complete`, {
+      method: 'post',
+    })
+  }
+
+  // 订阅任务进度，任务结束后返回最终状态
+  const waitJob = (jobId: string, onProgress: (progress: number, total: number) => void) => {
+    return new Promise<Job>((resolve, reject) => {
+      const es = new EventSource(`/api/jobs/${jobId}/events`)
+      es.onmessage = (event) => {
+        const { progress, total } = JSON.parse(event.data)
+        onProgress(progress, total)
+      }
+      es.addEventListener('done', (event) => {
+        es.close()
+        resolve(JSON.parse((event as MessageEvent).data))
+      })
+      es.onerror = () => {
+        es.close()
+        reject(new Error('任务进度连接中断'))
+      }
+    })
+  }
+
   const [msg, contextHolder] = message.useMessage()
   const { computeMD5 } = useComputeFileMD5()
   const { onPatch } = props
@@ -62,47 +103,62 @@ export const UploadAction: React.FC<UploadActionProps> = (props) => {
 
     const file = uploadFile as RcFile
     const chunkCount = Math.ceil(file.size / chunkSize)
-    const actions = []
-    for (let i = 0; i < chunkCount; i++) {
-      const start = i * chunkSize
-      const chunkData = file.slice(start, start + chunkSize)
-      actions.push(
-        buildUploadAction({
-          index: i,
-          chunkFile: chunkData,
-          chunkSize: chunkData.size,
-        }),
-      )
-    }
 
     setIsModalOpen(true)
-    const fileList = []
+    let session: UploadSession
     try {
-      setProgressTip('正在上传...')
-      const result = await Promise.all(actions)
-      for (const data of result) fileList.push(data.filename)
+      setProgressTip('正在校验文件...')
+      const md5 = await computeMD5(file, chunkSize)
+      session = await initUpload({
+        filename: file.name,
+        size: file.size,
+        chunkSize,
+        chunkCount,
+        md5,
+      })
     }
     catch (error) {
-      const statusText = '上传文件失败'
+      const statusText = error instanceof BusinessError ? error.message : '创建上传任务失败'
       onPatch?.(PatchResult.FAIL, statusText)
 
       setIsModalOpen(false)
       msg.error(statusText)
-      console.error('上传文件失败', error)
+      console.error('创建上传任务失败', error)
       return
     }
 
     try {
-      setProgressTip('正在校验文件...')
-      const md5 = await computeMD5(file, chunkSize)
+      // 跳过服务端已保存的分片，实现断点续传
+      const uploaded = new Set(session.uploaded)
+      const actions = []
+      for (let i = 0; i < chunkCount; i++) {
+        if (uploaded.has(i))
+          continue
+        const start = i * chunkSize
+        actions.push(uploadChunk(session.id, i, file.slice(start, start + chunkSize)))
+      }
+      setProgressTip(uploaded.size > 0 ? `正在续传（已上传 ${uploaded.size}/${chunkCount}）...` : '正在上传...')
+      await Promise.all(actions)
+    }
+    catch (error) {
+      const statusText = '上传文件失败，重新上传将从断点处继续'
+      onPatch?.(PatchResult.FAIL, statusText)
 
-      setProgressTip('正在合并依赖...')
-      const patchSuccess = await patchStorage(file.name, fileList, md5)
-      if (patchSuccess)
-        onPatch?.(PatchResult.SUCCESS, '合并依赖成功')
+      setIsModalOpen(false)
+      msg.error(statusText)
+      console.error('上传文件失败', error)
+      return
+    }
 
-      else
-        onPatch?.(PatchResult.FAIL, '合并依赖失败')
+    try {
+      setProgressTip('正在合并依赖...')
+      const job = await completeUpload(session.id)
+      const result = await waitJob(job.id, (progress, total) => {
+        setProgressTip(`正在合并依赖（${progress}/${total}）...`)
+      })
+      if (result.state !== 'succeeded')
+        throw new BusinessError(result.error || '合并依赖失败', -1)
+      onPatch?.(PatchResult.SUCCESS, '合并依赖成功')
     }
     catch (error) {
       if (error instanceof BusinessError) {
@@ -122,14 +178,14 @@ export const UploadAction: React.FC<UploadActionProps> = (props) => {
   const uploadProps: UploadProps = {
     showUploadList: false,
     beforeUpload: (file) => {
-      if (file.type !== 'application/zip' && file.type !== 'application/x-zip-compressed') {
-        msg.error('只能上传zip文件格式')
+      if (!BundleExtensions.some(ext => file.name.toLowerCase().endsWith(ext))) {
+        msg.error('只能上传 zip、tar、tar.gz、tar.zst 格式的文件')
         return false
       }
       setUploadFile(file)
       return false
     },
-    accept: '.zip',
+    accept: BundleExtensions.join(','),
   }
 
   return (
@@ -4,6 +4,7 @@ import { Tabs } from 'antd'
 import { useMemo, useState } from 'react'
 import ReactMarkdown from 'react-markdown'
 import remarkGfm from 'remark-gfm'
+import { PackageAdjustAction } from '@/components'
 
 export const Route = createFileRoute('/detail/$')({
   component: PackageDetailPage,
@@ -150,7 +151,7 @@ function PackageDetailPage() {
   const splat = (params as { _splat?: string })._splat ?? ''
   const name = useMemo(() => decodeURIComponent(splat), [splat])
 
-  const { data, isLoading, isError, error } = useQuery<DetailResp>({
+  const { data, isLoading, isError, error, refetch } = useQuery<DetailResp>({
     queryKey: ['package-detail', name],
     queryFn: () => fetchPackageDetail(name),
     enabled: Boolean(name),
@@ -236,6 +237,9 @@ function PackageDetailPage() {
           <span className="color-#d1d5db">/</span>
           {/* 包名面包屑 */}
           <span className="text-sm font-600 color-#111827 truncate">{pkg.name}</span>
+          <div className="ml-auto">
+            <PackageAdjustAction name={pkg.name} onAdjusted={() => refetch()} />
+          </div>
         </div>
       </header>
 